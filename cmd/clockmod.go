@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/util"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var clockmodCmd = &cobra.Command{
+	Use:   "clockmod",
+	Short: "On-demand Clock Modulation Interface",
+}
+
+var clockmodListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List clock modulation duty cycle per CPU",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listClockMod(cmd)
+	},
+}
+
+var clockmodSetCmd = &cobra.Command{
+	Use:   "set PERCENT",
+	Short: "Set clock modulation duty cycle (e.g. 50%; 100% turns it off)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		duty, err := util.ParsePercent(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := setClockMod(cmd, duty); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var clockmodOffCmd = &cobra.Command{
+	Use:   "off",
+	Short: "Turn clock modulation off",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setClockMod(cmd, 100); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	clockmodCmd.AddCommand(clockmodListCmd)
+	clockmodCmd.AddCommand(clockmodSetCmd)
+	clockmodCmd.AddCommand(clockmodOffCmd)
+	rootCmd.AddCommand(clockmodCmd)
+}
+
+func listClockMod(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		log.Fatal("Could not get list of CPUs: ", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CPU", "modulation", "duty cycle", "step"})
+	table.SetBorder(false)
+
+	for _, c := range cpus {
+		cm, err := msr.GetClockModulation(c)
+		if err != nil {
+			log.Fatalf("could not read clock modulation on cpu %d: %s", c, err)
+		}
+
+		step := "12.5%"
+		if cm.IsExtended() {
+			step = "6.25%"
+		}
+
+		table.Append([]string{strconv.Itoa(c), enabledString(cm.IsEnabled()), fmt.Sprintf("%0.2f%%", cm.GetDutyCycle()), step})
+	}
+
+	table.Render()
+	return nil
+}
+
+func setClockMod(cmd *cobra.Command, duty float64) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		cm, err := msr.GetClockModulation(c)
+		if err != nil {
+			return fmt.Errorf("could not read clock modulation on cpu %d: %s", c, err)
+		}
+
+		if err := cm.SetDutyCycle(duty); err != nil {
+			return fmt.Errorf("unable to set clock modulation: %s", err)
+		}
+		fmt.Printf("set CPU %d clock modulation duty cycle to %0.2f%%\n", c, cm.GetDutyCycle())
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var cstateCmd = &cobra.Command{
+	Use:   "cstate",
+	Short: "Core and Package C-state Residency Interface",
+}
+
+var cstateWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show core and package C-state residency every interval",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := watchCState(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	addWatchFlags(cstateWatchCmd)
+
+	cstateCmd.AddCommand(cstateWatchCmd)
+	rootCmd.AddCommand(cstateCmd)
+}
+
+func watchCState(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	limit, locked, err := msr.GetPackageCStateLimit(cpus[0])
+	if err != nil {
+		log.Warnf("could not read package C-state limit: %s", err)
+	} else {
+		fmt.Printf("package C-state limit: %s (locked: %t)\n\n", limit, locked)
+	}
+
+	coreSampler, err := msr.NewCStateSampler(cpus, msr.CoreCStates)
+	if err != nil {
+		return err
+	}
+
+	// package counters are the same on every CPU in the package, so only read them once
+	pkgSampler, err := msr.NewCStateSampler(cpus[:1], msr.PackageCStates)
+	if err != nil {
+		return err
+	}
+
+	smiSampler := newOptionalSMISampler(cpus)
+
+	return watchLoop(func() error {
+		coreSamples, err := coreSampler.Sample()
+		if err != nil {
+			return err
+		}
+
+		smiSamples := sampleOptionalSMIs(smiSampler)
+
+		pkgSamples, err := pkgSampler.Sample()
+		if err != nil {
+			return err
+		}
+
+		renderCStateTable("CPU", coreSampler.GetStates(), coreSamples, true, smiSamples)
+		renderCStateTable("package", pkgSampler.GetStates(), pkgSamples, false, nil)
+		fmt.Println()
+		return nil
+	})
+}
+
+// renderCStateTable prints one row per sample, with an SMI column if showSMIs is set.
+// smiSamples has to be in the same CPU order as samples; when it's nil (the SMI count
+// couldn't be read) the column shows n/a, so it doesn't come and go between refreshes.
+func renderCStateTable(label string, states []string, samples []msr.CStateSample, showSMIs bool, smiSamples []msr.SMISample) {
+	if len(states) == 0 {
+		return
+	}
+
+	header := append([]string{label}, states...)
+	if showSMIs {
+		header = append(header, "SMI/s")
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetBorder(false)
+
+	for i, s := range samples {
+		row := []string{strconv.Itoa(s.CPU)}
+		for _, state := range states {
+			row = append(row, fmt.Sprintf("%0.2f%%", s.Residency[state]))
+		}
+		if showSMIs {
+			row = append(row, formatOptionalSMIRate(smiSamples, i))
+		}
+		table.Append(row)
+	}
+
+	table.Render()
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ctdpCmd = &cobra.Command{
+	Use:   "ctdp",
+	Short: "Configurable TDP (cTDP) Interface",
+}
+
+var ctdpListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List Config TDP levels and the active level",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listConfigTDP(cpuFlag)
+	},
+}
+
+var ctdpSetCmd = &cobra.Command{
+	Use:       "set nominal|up|down",
+	Short:     "Switch the active Config TDP level",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: msr.ConfigTDPLevels,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setConfigTDP(cpuFlag, args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	ctdpCmd.AddCommand(ctdpListCmd)
+	ctdpCmd.AddCommand(ctdpSetCmd)
+	rootCmd.AddCommand(ctdpCmd)
+}
+
+func listConfigTDP(cpu int) error {
+	ctdp, err := msr.GetConfigTDP(cpu)
+	if err != nil {
+		log.Fatalf("could not retreive config TDP information for cpu %d: %s", cpu, err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"level", "name", "ratio", "frequency", "TDP", "min power", "max power", "active"})
+	table.SetBorder(false)
+
+	for _, l := range ctdp.GetLevels() {
+		active := ""
+		if l.Level == ctdp.GetActiveLevel() {
+			active = "*"
+		}
+
+		table.Append([]string{
+			strconv.Itoa(l.Level),
+			ctdp.GetLevelName(l.Level),
+			strconv.Itoa(l.Ratio),
+			fmt.Sprintf("%d MHz", msr.RatioToMHz(l.Ratio)),
+			fmt.Sprintf("%0.2f W", l.TDP),
+			fmt.Sprintf("%0.2f W", l.MinPower),
+			fmt.Sprintf("%0.2f W", l.MaxPower),
+			active,
+		})
+	}
+
+	table.Render()
+
+	tar, tarLocked := ctdp.GetTurboActivationRatio()
+	fmt.Printf("config TDP control locked: %t\n", ctdp.IsLocked())
+	fmt.Printf("turbo activation ratio: %d (locked: %t)\n", tar, tarLocked)
+	return nil
+}
+
+func setConfigTDP(cpu int, levelName string) error {
+	ctdp, err := msr.GetConfigTDP(cpu)
+	if err != nil {
+		return fmt.Errorf("could not read config TDP data: %s", err)
+	}
+
+	level, err := ctdp.GetLevelByName(levelName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("setting CPU", cpu, "config TDP level to", level, "("+levelName+")")
+	err = ctdp.SetLevel(level)
+	if err != nil {
+		return fmt.Errorf("unable to set config TDP level: %s", err)
+	}
+
+	return nil
+}
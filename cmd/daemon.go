@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/api"
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/control"
+	"github.com/davidr/ddtp/pkg/daemon"
+	"github.com/davidr/ddtp/pkg/procwatch"
+	"github.com/davidr/ddtp/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonState        daemon.State
+	daemonIntervalFlag time.Duration
+	daemonAutoFlag     bool
+	daemonDebounceFlag time.Duration
+	daemonProfileFlag  string
+	daemonNoTriggers   bool
+	daemonNoAPI        bool
+	controlConfig      control.Config
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Hold power limits and throttle temperature, re-applying them when firmware resets them",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		modes := 0
+		for _, set := range []bool{!daemonState.IsEmpty(), daemonAutoFlag, daemonProfileFlag != ""} {
+			if set {
+				modes++
+			}
+		}
+		controlling := cmd.Flags().Changed("target-temp")
+		if modes > 1 || (modes == 0 && !controlling) {
+			log.Fatal("give exactly one of --pl1/--pl2/--temp, --profile or --auto, and/or --target-temp")
+		}
+
+		// Profiles can carry per-thread settings (EPP, clock modulation), so they go to every
+		// CPU; the daemon itself only checks one CPU per package, and with nothing but
+		// package-scoped limits to hold, that's all it needs
+		cpus, err := getPerThreadCPUs(cmd)
+		if err != nil {
+			log.Fatal("Could not get list of CPUs: ", err)
+		}
+		if !daemonAutoFlag && daemonProfileFlag == "" {
+			cpus = util.GetPackageCPUs(cpus)
+		}
+
+		// Corrections are logged as warnings, but the daemon should say what it's up to
+		// even without -v
+		if log.GetLevel() < log.InfoLevel {
+			log.SetLevel(log.InfoLevel)
+		}
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		d := daemon.New(cpus, daemonIntervalFlag, daemonState)
+		var cfg *config.Config
+		switch {
+		case daemonAutoFlag:
+			cfg = loadConfig()
+			d, err = daemon.NewAuto(cpus, daemonIntervalFlag, cfg, daemonDebounceFlag)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case daemonProfileFlag != "":
+			cfg = loadConfig()
+			p, err := cfg.GetProfile(daemonProfileFlag)
+			if err != nil {
+				log.Fatal(err)
+			}
+			d = daemon.NewProfile(cpus, daemonIntervalFlag, p)
+		default:
+			// Holding flags rather than a profile, the config file is only wanted for
+			// the api section, so it's fine for it not to be there
+			if _, err := os.Stat(configFlag); err == nil {
+				cfg = loadConfig()
+			}
+		}
+
+		// Triggers come along with the profiles, unless they're turned off
+		profiles := daemonAutoFlag || daemonProfileFlag != ""
+		if profiles && len(cfg.Triggers) > 0 && !daemonNoTriggers {
+			if err := d.SetTriggers(cfg); err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("watching %s for %d triggers", procwatch.ProcRoot, len(cfg.Triggers))
+		}
+
+		if controlling {
+			controlConfig.CPU = cpus[0]
+			c, err := control.New(controlConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			d.SetController(c)
+		}
+
+		if cfg != nil && cfg.API != nil && !daemonNoAPI {
+			server, err := api.NewServer(d, cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			l, err := server.Listen()
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.Remove(server.GetSocket())
+			defer l.Close()
+
+			log.Infof("serving the api on %s", server.GetSocket())
+			go server.Serve(l)
+		}
+
+		d.Run(stop)
+	},
+}
+
+func init() {
+	daemonCmd.Flags().Float64Var(&daemonState.PL1, "pl1", 0, "Package power limit (PL1) to hold in W")
+	daemonCmd.Flags().Float64Var(&daemonState.PL2, "pl2", 0, "Short term package power limit (PL2) to hold in W")
+	daemonCmd.Flags().IntVar(&daemonState.ThrottleTemp, "temp", 0, "Throttle temperature to hold in C")
+	daemonCmd.Flags().DurationVarP(&daemonIntervalFlag, "interval", "i", 5*time.Second, "How often to check for drift")
+	daemonCmd.Flags().StringVar(&daemonProfileFlag, "profile", "", "Apply this profile and hold it, re-applying it on resume")
+	daemonCmd.Flags().BoolVar(&daemonAutoFlag, "auto", false, "Switch between the ac and battery profiles as the power source changes")
+	daemonCmd.Flags().DurationVar(&daemonDebounceFlag, "debounce", 3*time.Second, "How long a power source change has to last before switching profiles")
+	daemonCmd.Flags().BoolVar(&daemonNoTriggers, "no-triggers", false, "Ignore the triggers in the config file")
+	daemonCmd.Flags().BoolVar(&daemonNoAPI, "no-api", false, "Don't serve the api, even if the config file has an api section")
+	daemonCmd.Flags().StringVar(&procwatch.ProcRoot, "proc-root", procwatch.ProcRoot, "Where procfs is mounted, for matching triggers")
+
+	daemonCmd.Flags().Float64Var(&controlConfig.Target, "target-temp", 0, "Adjust PL1 to hold the package at this temperature in C")
+	daemonCmd.Flags().Float64Var(&controlConfig.MinPL1, "min-pl1", 5, "Lowest PL1 the controller may set in W")
+	daemonCmd.Flags().Float64Var(&controlConfig.MaxPL1, "max-pl1", 0, "Highest PL1 the controller may set in W")
+	daemonCmd.Flags().Float64Var(&controlConfig.Kp, "kp", 0.5, "Controller proportional gain (W/C)")
+	daemonCmd.Flags().Float64Var(&controlConfig.Ki, "ki", 0.05, "Controller integral gain (W/C/s)")
+	daemonCmd.Flags().Float64Var(&controlConfig.Kd, "kd", 0, "Controller derivative gain (W/(C/s))")
+	daemonCmd.Flags().Float64Var(&controlConfig.MaxRate, "max-rate", 2, "Largest change the controller may make to PL1 in W/s")
+	daemonCmd.Flags().DurationVar(&controlConfig.Interval, "control-interval", time.Second, "How often the controller updates PL1")
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var epbCmd = &cobra.Command{
+	Use:   "epb",
+	Short: "Energy Performance Bias Interface",
+}
+
+var epbGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Show the Energy Performance Bias hint per CPU",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listEPB(cmd)
+	},
+}
+
+var epbSetCmd = &cobra.Command{
+	Use:   "set performance|balance-performance|normal|balance-power|power|0-15",
+	Short: "Set the Energy Performance Bias hint",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		epb, err := msr.ParseEPB(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := setEPB(cmd, epb); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	epbCmd.AddCommand(epbGetCmd)
+	epbCmd.AddCommand(epbSetCmd)
+	rootCmd.AddCommand(epbCmd)
+}
+
+func listEPB(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		log.Fatal("Could not get list of CPUs: ", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CPU", "EPB", "name"})
+	table.SetBorder(false)
+
+	for _, c := range cpus {
+		epb, err := msr.GetEnergyPerfBias(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		table.Append([]string{strconv.Itoa(c), strconv.Itoa(epb), msr.EPBName(epb)})
+	}
+
+	table.Render()
+	return nil
+}
+
+func setEPB(cmd *cobra.Command, epb int) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		fmt.Println("setting CPU", c, "EPB to", epb)
+		if err := msr.SetEnergyPerfBias(c, epb); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchIntervalFlag time.Duration
+	watchCountFlag    int
+)
+
+var freqCmd = &cobra.Command{
+	Use:   "freq",
+	Short: "Effective Frequency (APERF/MPERF) Interface",
+}
+
+var freqWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show effective MHz, busy % and busy MHz per CPU every interval",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := watchFreq(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	addWatchFlags(freqWatchCmd)
+
+	freqCmd.AddCommand(freqWatchCmd)
+	rootCmd.AddCommand(freqCmd)
+}
+
+// addWatchFlags adds the --interval and --count flags shared by the "watch" commands
+func addWatchFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVarP(&watchIntervalFlag, "interval", "i", time.Second, "Sampling interval")
+	cmd.Flags().IntVarP(&watchCountFlag, "count", "n", 0, "Number of samples to show (0 to run until interrupted)")
+}
+
+// watchLoop calls sample every watchIntervalFlag until watchCountFlag samples have been
+// taken, or forever if it's 0
+func watchLoop(sample func() error) error {
+	for i := 0; watchCountFlag == 0 || i < watchCountFlag; i++ {
+		time.Sleep(watchIntervalFlag)
+		if err := sample(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func watchFreq(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	sampler, err := msr.NewFrequencySampler(cpus)
+	if err != nil {
+		return err
+	}
+
+	smiSampler := newOptionalSMISampler(cpus)
+
+	return watchLoop(func() error {
+		samples, err := sampler.Sample()
+		if err != nil {
+			return err
+		}
+
+		smiSamples := sampleOptionalSMIs(smiSampler)
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"CPU", "Avg_MHz", "Busy%", "Bzy_MHz", "TSC_MHz", "SMI/s"})
+		table.SetBorder(false)
+
+		for i, s := range samples {
+			table.Append([]string{
+				strconv.Itoa(s.CPU),
+				fmt.Sprintf("%0.0f", s.AvgMHz),
+				fmt.Sprintf("%0.2f", s.Busy),
+				fmt.Sprintf("%0.0f", s.BusyMHz),
+				fmt.Sprintf("%0.0f", s.TSCMHz),
+				formatOptionalSMIRate(smiSamples, i),
+			})
+		}
+
+		table.Render()
+		fmt.Println()
+		return nil
+	})
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/power"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var hookProfileFlag string
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "Entry points for system hooks (e.g. systemd-sleep)",
+}
+
+var hookPostSleepCmd = &cobra.Command{
+	Use:   "post-sleep",
+	Short: "Re-apply a profile after resume from suspend",
+	Long: `Re-apply a profile after resume from suspend, and check that it stuck. Voltage offsets
+and power limits are lost on resume. Call this from a systemd-sleep hook, e.g. in
+/usr/lib/systemd/system-sleep/ddtp:
+
+  #!/bin/sh
+  [ "$1" = post ] && exec ddtp hook post-sleep
+
+Without --profile, the "ac" or "battery" profile is picked by the power source.`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := postSleep(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	hookPostSleepCmd.Flags().StringVar(&hookProfileFlag, "profile", "", "Profile to re-apply (default: ac or battery, by power source)")
+
+	hookCmd.AddCommand(hookPostSleepCmd)
+	rootCmd.AddCommand(hookCmd)
+}
+
+func postSleep(cmd *cobra.Command) error {
+	name := hookProfileFlag
+	if name == "" {
+		var err error
+		if name, err = power.GetSource(); err != nil {
+			return fmt.Errorf("could not read power source: %s", err)
+		}
+	}
+
+	p, err := loadConfig().GetProfile(name)
+	if err != nil {
+		return err
+	}
+
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	fmt.Println("re-applying profile", p.Name, "to CPUs", cpus)
+	if err := p.ApplyAndVerify(cpus, 3, time.Second); err != nil {
+		return err
+	}
+
+	fmt.Println("profile", p.Name, "applied and verified")
+	return nil
+}
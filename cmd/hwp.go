@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/cpufreq"
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hwpEPPFlag         string
+	hwpMinPerfFlag     int
+	hwpMaxPerfFlag     int
+	hwpDesiredPerfFlag int
+	hwpWindowFlag      time.Duration
+	hwpForceMSRFlag    bool
+)
+
+var hwpCmd = &cobra.Command{
+	Use:   "hwp",
+	Short: "Hardware P-state (HWP/Speed Shift) and Energy Performance Preference Interface",
+}
+
+var hwpListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List HWP capabilities and requests per CPU",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listHWP(cmd)
+	},
+}
+
+var hwpSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set HWP request fields (--epp, --min-perf, --max-perf, --desired-perf, --window)",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setHWP(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	hwpSetCmd.Flags().StringVar(&hwpEPPFlag, "epp", "", "Energy Performance Preference (0-255 or performance|balance_performance|balance_power|power)")
+	hwpSetCmd.Flags().IntVar(&hwpMinPerfFlag, "min-perf", 0, "Minimum performance level")
+	hwpSetCmd.Flags().IntVar(&hwpMaxPerfFlag, "max-perf", 0, "Maximum performance level")
+	hwpSetCmd.Flags().IntVar(&hwpDesiredPerfFlag, "desired-perf", 0, "Desired performance level (0 for autonomous)")
+	hwpSetCmd.Flags().DurationVar(&hwpWindowFlag, "window", 0, "Activity window, e.g. 5ms (0 for autonomous)")
+	hwpSetCmd.Flags().BoolVar(&hwpForceMSRFlag, "msr", false, "Write EPP to the MSR even if cpufreq exposes it")
+
+	hwpCmd.AddCommand(hwpListCmd)
+	hwpCmd.AddCommand(hwpSetCmd)
+	rootCmd.AddCommand(hwpCmd)
+}
+
+func listHWP(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		log.Fatal("Could not get list of CPUs: ", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CPU", "HWP", "lowest", "efficient", "guaranteed", "highest", "min", "max", "desired", "EPP", "window", "cpufreq EPP"})
+	table.SetBorder(false)
+
+	for _, c := range cpus {
+		enabled, err := msr.IsHWPEnabled(c)
+		if err != nil {
+			log.Fatalf("could not read HWP state on cpu %d: %s", c, err)
+		}
+
+		if !enabled {
+			table.Append([]string{strconv.Itoa(c), enabledString(enabled), "", "", "", "", "", "", "", "", "", ""})
+			continue
+		}
+
+		caps, err := msr.GetHWPCapabilities(c)
+		if err != nil {
+			log.Fatalf("could not read HWP capabilities on cpu %d: %s", c, err)
+		}
+
+		req, err := msr.GetHWPRequest(c)
+		if err != nil {
+			log.Fatalf("could not read HWP request on cpu %d: %s", c, err)
+		}
+
+		sysfsEPP := "n/a"
+		if cpufreq.HasEnergyPerformancePreference(c) {
+			sysfsEPP, _ = cpufreq.GetEnergyPerformancePreference(c)
+		}
+
+		table.Append([]string{
+			strconv.Itoa(c),
+			enabledString(enabled),
+			strconv.Itoa(caps.Lowest),
+			strconv.Itoa(caps.MostEfficient),
+			strconv.Itoa(caps.Guaranteed),
+			strconv.Itoa(caps.Highest),
+			strconv.Itoa(req.GetMinPerf()),
+			strconv.Itoa(req.GetMaxPerf()),
+			strconv.Itoa(req.GetDesiredPerf()),
+			strconv.Itoa(req.GetEPP()),
+			req.GetWindow().String(),
+			sysfsEPP,
+		})
+	}
+
+	table.Render()
+	return nil
+}
+
+func setHWP(cmd *cobra.Command) error {
+	setEPP := cmd.Flags().Changed("epp")
+	setPerf := cmd.Flags().Changed("min-perf") || cmd.Flags().Changed("max-perf") || cmd.Flags().Changed("desired-perf") || cmd.Flags().Changed("window")
+	if !setEPP && !setPerf {
+		return fmt.Errorf("nothing to set; use --epp, --min-perf, --max-perf, --desired-perf or --window")
+	}
+
+	epp := 0
+	if setEPP {
+		var err error
+		if epp, err = msr.ParseEPP(hwpEPPFlag); err != nil {
+			return err
+		}
+	}
+
+	// Like the rest of the setters, this touches --cpu (0 by default) unless --cpus says
+	// otherwise; only list shows every CPU by default
+	cpus, err := getCPUs(cpuFlag)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		// When intel_pstate owns HWP, it caches EPP and will stomp on anything we write to
+		// the MSR directly, so go through the driver when it offers us the option
+		if setEPP && !hwpForceMSRFlag && cpufreq.HasEnergyPerformancePreference(c) {
+			fmt.Println("setting CPU", c, "EPP to", hwpEPPFlag, "via cpufreq")
+			if err := cpufreq.SetEnergyPerformancePreference(c, hwpEPPFlag); err != nil {
+				return err
+			}
+
+			if !setPerf {
+				continue
+			}
+		}
+
+		enabled, err := msr.IsHWPEnabled(c)
+		if err != nil {
+			return fmt.Errorf("could not read HWP state on cpu %d: %s", c, err)
+		}
+		if !enabled {
+			return fmt.Errorf("HWP is not enabled on cpu %d", c)
+		}
+
+		req, err := msr.GetHWPRequest(c)
+		if err != nil {
+			return fmt.Errorf("could not read HWP request on cpu %d: %s", c, err)
+		}
+
+		if setEPP && (hwpForceMSRFlag || !cpufreq.HasEnergyPerformancePreference(c)) {
+			fmt.Println("setting CPU", c, "EPP to", epp)
+			if err := req.SetEPP(epp); err != nil {
+				return err
+			}
+		}
+
+		if cmd.Flags().Changed("min-perf") {
+			fmt.Println("setting CPU", c, "min perf to", hwpMinPerfFlag)
+			if err := req.SetMinPerf(hwpMinPerfFlag); err != nil {
+				return err
+			}
+		}
+
+		if cmd.Flags().Changed("max-perf") {
+			fmt.Println("setting CPU", c, "max perf to", hwpMaxPerfFlag)
+			if err := req.SetMaxPerf(hwpMaxPerfFlag); err != nil {
+				return err
+			}
+		}
+
+		if cmd.Flags().Changed("desired-perf") {
+			fmt.Println("setting CPU", c, "desired perf to", hwpDesiredPerfFlag)
+			if err := req.SetDesiredPerf(hwpDesiredPerfFlag); err != nil {
+				return err
+			}
+		}
+
+		if cmd.Flags().Changed("window") {
+			fmt.Println("setting CPU", c, "activity window to", hwpWindowFlag)
+			if err := req.SetActivityWindow(hwpWindowFlag); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
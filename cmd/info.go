@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show platform ratios, frequencies and which limits are programmable",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		showPlatformInfo(cpuFlag)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+}
+
+func showPlatformInfo(cpu int) error {
+	pi, err := msr.GetPlatformInfo(cpu)
+	if err != nil {
+		log.Fatalf("could not read platform info on cpu %d: %s", cpu, err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"", "ratio", "frequency"})
+	table.SetBorder(false)
+
+	for _, r := range []struct {
+		name  string
+		ratio int
+	}{
+		{"max non-turbo (base)", pi.GetMaxNonTurboRatio()},
+		{"max efficiency", pi.GetMaxEfficiencyRatio()},
+		{"min operating", pi.GetMinOperatingRatio()},
+	} {
+		table.Append([]string{r.name, strconv.Itoa(r.ratio), fmt.Sprintf("%d MHz", msr.RatioToMHz(r.ratio))})
+	}
+
+	table.Render()
+
+	fmt.Println("turbo ratio limits programmable:", pi.IsRatioProgrammable())
+	fmt.Println("turbo power/current limits programmable:", pi.IsTDPProgrammable())
+	fmt.Println("TCC offset programmable:", pi.IsTCCOffsetProgrammable())
+	fmt.Println("config TDP levels:", pi.GetConfigTDPLevels()+1)
+	return nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	limitsSampleFlag   time.Duration
+	limitsIntervalFlag time.Duration
+	limitsClearFlag    bool
+)
+
+var limitsCmd = &cobra.Command{
+	Use:   "limits",
+	Short: "Show which limits are capping core, graphics and ring frequency",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		domains := getPerfLimitDomains(cpuFlag)
+		if len(domains) == 0 {
+			log.Fatalf("could not read any perf limit reasons registers on cpu %d", cpuFlag)
+		}
+
+		if limitsSampleFlag > 0 {
+			samplePerfLimits(cpuFlag, domains)
+			return
+		}
+
+		listPerfLimits(cpuFlag, domains)
+	},
+}
+
+func init() {
+	limitsCmd.Flags().DurationVarP(&limitsSampleFlag, "sample", "s", 0, "Sample active reasons for this long (e.g. 10s)")
+	limitsCmd.Flags().DurationVarP(&limitsIntervalFlag, "interval", "i", 100*time.Millisecond, "Sampling interval")
+	limitsCmd.Flags().BoolVar(&limitsClearFlag, "clear", false, "Clear the logged reasons after displaying them")
+	rootCmd.AddCommand(limitsCmd)
+}
+
+// getPerfLimitDomains returns the perf limit domains whose registers can be read on cpu. Not
+// every CPU has the graphics and ring registers.
+func getPerfLimitDomains(cpu int) []string {
+	var domains []string
+	for _, domain := range msr.PerfLimitDomains {
+		if _, err := msr.GetPerfLimitReasons(cpu, domain); err != nil {
+			log.Infof("skipping %s perf limit reasons: %s", domain, err)
+			continue
+		}
+
+		domains = append(domains, domain)
+	}
+
+	return domains
+}
+
+func listPerfLimits(cpu int, domains []string) error {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"domain", "active", "logged"})
+	table.SetBorder(false)
+
+	for _, domain := range domains {
+		plr, err := msr.GetPerfLimitReasons(cpu, domain)
+		if err != nil {
+			log.Fatalf("could not read %s perf limit reasons: %s", domain, err)
+		}
+
+		table.Append([]string{domain, strings.Join(plr.GetActive(), ", "), strings.Join(plr.GetLogged(), ", ")})
+
+		if limitsClearFlag {
+			if err := plr.ClearLog(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	table.Render()
+	return nil
+}
+
+func samplePerfLimits(cpu int, domains []string) error {
+	smiSampler := newOptionalSMISampler([]int{cpu})
+
+	fmt.Printf("sampling perf limit reasons on cpu %d for %s...\n", cpu, limitsSampleFlag)
+	samples, err := msr.SamplePerfLimitReasons(cpu, domains, limitsSampleFlag, limitsIntervalFlag)
+	if err != nil {
+		log.Fatalf("could not sample perf limit reasons: %s", err)
+	}
+
+	smiSamples := sampleOptionalSMIs(smiSampler)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"domain", "reason", "% of time active"})
+	table.SetBorder(false)
+
+	for _, sample := range samples {
+		for _, reason := range sample.GetReasons() {
+			table.Append([]string{sample.Domain, reason, fmt.Sprintf("%0.1f", sample.Percents[reason])})
+		}
+	}
+
+	table.Render()
+	if smiSamples != nil {
+		fmt.Printf("SMIs: %d (%s/s)\n", smiSamples[0].Count, formatSMIRate(smiSamples[0]))
+	} else {
+		fmt.Println("SMIs: n/a")
+	}
+	return nil
+}
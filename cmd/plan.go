@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/util"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// driftExitCode is what plan and apply exit with under --check when the live state doesn't
+// match the file
+const driftExitCode = 2
+
+var (
+	planFileFlag    string
+	planProfileFlag string
+	planCheckFlag   bool
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show how the live registers differ from a profile",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, cpus := loadPlanProfile(cmd)
+
+		changes, err := p.Diff(cpus)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("no changes; profile", p.Name, "is in place")
+			return
+		}
+
+		renderChanges(changes)
+		if planCheckFlag {
+			os.Exit(driftExitCode)
+		}
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply the settings from a profile that differ from the live registers",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, cpus := loadPlanProfile(cmd)
+
+		// --check is a dry run: say what would change, and fail if anything would
+		if planCheckFlag {
+			changes, err := p.Diff(cpus)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if len(changes) > 0 {
+				renderChanges(changes)
+				os.Exit(driftExitCode)
+			}
+
+			fmt.Println("no changes; profile", p.Name, "is in place")
+			return
+		}
+
+		applied, err := p.ApplyDiff(cpus)
+		if len(applied) > 0 {
+			renderChanges(applied)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := p.Verify(cpus); err != nil {
+			log.Fatal(err)
+		}
+
+		if len(applied) == 0 {
+			fmt.Println("no changes; profile", p.Name, "is in place")
+			return
+		}
+		fmt.Println("applied", len(applied), "changes from profile", p.Name)
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{planCmd, applyCmd} {
+		c.Flags().StringVarP(&planFileFlag, "file", "f", "", "Config file to take the desired state from")
+		c.Flags().StringVar(&planProfileFlag, "profile", "", "Profile in the file to use (default: the only one)")
+		c.Flags().BoolVar(&planCheckFlag, "check", false, fmt.Sprintf("Change nothing; exit %d if the live state differs", driftExitCode))
+		c.MarkFlagRequired("file")
+		rootCmd.AddCommand(c)
+	}
+}
+
+// loadPlanProfile loads the profile plan and apply work on, and the CPUs to compare it on
+func loadPlanProfile(cmd *cobra.Command) (*config.Profile, []int) {
+	cfg, err := config.Load(planFileFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := planProfileFlag
+	if name == "" {
+		names := cfg.Names()
+		if len(names) != 1 {
+			log.Fatalf("%s has %d profiles; pick one with --profile", planFileFlag, len(names))
+		}
+		name = names[0]
+	}
+
+	p, err := cfg.GetProfile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		log.Fatal("Could not get list of CPUs: ", err)
+	}
+
+	return p, cpus
+}
+
+// renderChanges prints a table of changes, collapsing the same change on several CPUs
+// (which is most of them, since so much is package-scoped) into one row
+func renderChanges(changes []config.Change) {
+	type row struct {
+		key, old, new string
+	}
+
+	var rows []row
+	cpus := make(map[row][]int)
+	for _, c := range changes {
+		r := row{c.Key, c.Old, c.New}
+		if _, ok := cpus[r]; !ok {
+			rows = append(rows, r)
+		}
+		cpus[r] = append(cpus[r], c.CPU)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"setting", "CPUs", "current", "desired"})
+	table.SetBorder(false)
+
+	for _, r := range rows {
+		table.Append([]string{r.key, util.FormatCPUList(cpus[r]), r.old, r.new})
+	}
+
+	table.Render()
+}
@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/spf13/cobra"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/daemon"
 	"github.com/davidr/ddtp/pkg/msr"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 )
 
 var powerlimitCmd = &cobra.Command{
@@ -30,16 +34,36 @@ var powerlimitListCmd = &cobra.Command{
 }
 
 var powerlimitSetCmd = &cobra.Command{
-	Use:   "set",
-	Short: "Set plane powerlimitage value",
-	Args:  cobra.ExactArgs(2),
+	Use:         "set WATTS",
+	Short:       "Set Package power limit (PL1) in W",
+	Args:        cobra.ExactArgs(1),
+	Annotations: remoteAnnotations,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("powerlimit set...")
+		watts, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			log.Fatal("Could not parse argument into power limit: ", err)
+		}
+
+		if useRemote() {
+			setRemoteLimits(daemon.State{PL1: watts})
+			return
+		}
 
+		powerlimit, err := msr.GetRAPLPowerLimit(cpuFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println("setting CPU", cpuFlag, "power limit to", watts, "W")
+		if err := powerlimit.SetPowerLimit(watts); err != nil {
+			log.Fatal("unable to set power limit: ", err)
+		}
 	},
 }
 
 func init() {
+	powerlimitSetCmd.Flags().DurationVar(&remoteForFlag, "for", 0, "With --remote, how long the daemon should hold the limit (default: until released)")
+
 	powerlimitCmd.AddCommand(powerlimitListCmd)
 	powerlimitCmd.AddCommand(powerlimitSetCmd)
 	rootCmd.AddCommand(powerlimitCmd)
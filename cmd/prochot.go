@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var prochotCmd = &cobra.Command{
+	Use:   "prochot",
+	Short: "Bidirectional PROCHOT and C1E (MSR_POWER_CTL) Interface",
+}
+
+var prochotStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show BD PROCHOT and C1E state and whether PROCHOT is being asserted",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		prochotStatus(cpuFlag)
+	},
+}
+
+var prochotDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Disable bidirectional PROCHOT so external PROCHOT# is ignored",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setBDProchot(cpuFlag, false); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var prochotEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Enable bidirectional PROCHOT",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setBDProchot(cpuFlag, true); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var prochotC1ECmd = &cobra.Command{
+	Use:       "c1e enable|disable",
+	Short:     "Enable or disable C1E",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"enable", "disable"},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setC1E(cpuFlag, args[0] == "enable"); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	prochotCmd.AddCommand(prochotStatusCmd)
+	prochotCmd.AddCommand(prochotDisableCmd)
+	prochotCmd.AddCommand(prochotEnableCmd)
+	prochotCmd.AddCommand(prochotC1ECmd)
+	rootCmd.AddCommand(prochotCmd)
+}
+
+func prochotStatus(cpu int) error {
+	cpus, err := getCPUs(cpu)
+	if err != nil {
+		log.Fatal("Could not get list of CPUs: ", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CPU", "BD PROCHOT", "C1E"})
+	table.SetBorder(false)
+
+	for _, c := range cpus {
+		pc, err := msr.GetPowerCtl(c)
+		if err != nil {
+			log.Fatalf("could not read power ctl on cpu %d: %s", c, err)
+		}
+
+		table.Append([]string{strconv.Itoa(c), enabledString(pc.IsBDProchotEnabled()), enabledString(pc.IsC1EEnabled())})
+	}
+
+	table.Render()
+
+	// PROCHOT is package-wide, so there's no point in checking every CPU
+	pts, err := msr.GetPackageThermalStatus(cpus[0])
+	if err != nil {
+		log.Fatalf("could not read package thermal status: %s", err)
+	}
+
+	asserted, logged := pts.IsProchotAsserted()
+	if asserted {
+		fmt.Println("WARNING: PROCHOT is currently asserted. The CPU is being held at its minimum frequency.")
+	} else if logged {
+		fmt.Println("WARNING: PROCHOT has been asserted since boot (or since the log was last cleared).")
+	}
+
+	return nil
+}
+
+func setBDProchot(cpu int, enabled bool) error {
+	cpus, err := getCPUs(cpu)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		pc, err := msr.GetPowerCtl(c)
+		if err != nil {
+			return fmt.Errorf("could not read power ctl on cpu %d: %s", c, err)
+		}
+
+		fmt.Println("setting CPU", c, "BD PROCHOT to", enabledString(enabled))
+		if err := pc.SetBDProchot(enabled); err != nil {
+			return fmt.Errorf("unable to set BD PROCHOT: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func setC1E(cpu int, enabled bool) error {
+	cpus, err := getCPUs(cpu)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		pc, err := msr.GetPowerCtl(c)
+		if err != nil {
+			return fmt.Errorf("could not read power ctl on cpu %d: %s", c, err)
+		}
+
+		fmt.Println("setting CPU", c, "C1E to", enabledString(enabled))
+		if err := pc.SetC1E(enabled); err != nil {
+			return fmt.Errorf("unable to set C1E: %s", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/davidr/ddtp/pkg/api"
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/daemon"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Named profiles from the config file",
+}
+
+var profileListCmd = &cobra.Command{
+	Use:         "list",
+	Short:       "List the profiles in the config file",
+	Args:        cobra.ExactArgs(0),
+	Annotations: remoteAnnotations,
+	Run: func(cmd *cobra.Command, args []string) {
+		var profiles []api.ProfileInfo
+		if useRemote() {
+			remoteCall("profile.list", nil, &profiles)
+		} else {
+			cfg := loadConfig()
+			for _, name := range cfg.Names() {
+				p, _ := cfg.GetProfile(name)
+				profiles = append(profiles, api.ProfileInfo{Name: name, Settings: p.Fields()})
+			}
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"profile", "settings"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+
+		for _, p := range profiles {
+			var settings []string
+			for _, f := range p.Settings {
+				settings = append(settings, f.Key+"="+f.Value)
+			}
+
+			table.Append([]string{p.Name, strings.Join(settings, " ")})
+		}
+
+		table.Render()
+	},
+}
+
+var profileShowCmd = &cobra.Command{
+	Use:   "show NAME",
+	Short: "Show the settings in a profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := loadConfig().GetProfile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"setting", "value"})
+		table.SetBorder(false)
+
+		for _, f := range p.Fields() {
+			table.Append([]string{f.Key, f.Value})
+		}
+
+		table.Render()
+	},
+}
+
+var profileApplyCmd = &cobra.Command{
+	Use:         "apply NAME",
+	Short:       "Apply the settings in a profile",
+	Long:        "Apply the settings in a profile. Over --remote, the daemon holds it (for --for, or until released).",
+	Args:        cobra.ExactArgs(1),
+	Annotations: remoteAnnotations,
+	Run: func(cmd *cobra.Command, args []string) {
+		if useRemote() {
+			var status daemon.Status
+			remoteCall("profile.switch", api.SwitchParams{Profile: args[0], Duration: remoteDuration()}, &status)
+			renderStatus(status)
+			return
+		}
+
+		p, err := loadConfig().GetProfile(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cpus, err := getPerThreadCPUs(cmd)
+		if err != nil {
+			log.Fatal("Could not get list of CPUs: ", err)
+		}
+
+		fmt.Println("applying profile", p.Name, "to CPUs", cpus)
+		if err := p.Apply(cpus); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var profileSnapshotCmd = &cobra.Command{
+	Use:   "snapshot NAME",
+	Short: "Print the current settings as a profile called NAME, for pasting into the config file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p, err := config.Snapshot(args[0], cpuFlag, config.SnapshotKeys)
+		if err != nil {
+			// Not every part has every setting; leave out what this one doesn't
+			log.Warn(err)
+		}
+
+		fmt.Print(p.YAML())
+	},
+}
+
+func init() {
+	profileApplyCmd.Flags().DurationVar(&remoteForFlag, "for", 0, "With --remote, how long the daemon should hold the profile (default: until released)")
+
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileShowCmd)
+	profileCmd.AddCommand(profileApplyCmd)
+	profileCmd.AddCommand(profileSnapshotCmd)
+	rootCmd.AddCommand(profileCmd)
+}
+
+// loadConfig loads the config file given by --config, exiting if it's missing or invalid
+func loadConfig() *config.Config {
+	cfg, err := config.Load(configFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return cfg
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/api"
+	"github.com/davidr/ddtp/pkg/daemon"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// remoteAnnotation marks the commands that can go through the daemon's socket
+const remoteAnnotation = "remote"
+
+var remoteAnnotations = map[string]string{remoteAnnotation: "true"}
+
+var (
+	remoteFlag    bool
+	socketFlag    string
+	remoteForFlag time.Duration
+)
+
+var statusCmd = &cobra.Command{
+	Use:         "status",
+	Short:       "Show what the daemon is doing",
+	Args:        cobra.ExactArgs(0),
+	Annotations: remoteAnnotations,
+	Run: func(cmd *cobra.Command, args []string) {
+		var status daemon.Status
+		remoteCall("status", nil, &status)
+		renderStatus(status)
+	},
+}
+
+var releaseCmd = &cobra.Command{
+	Use:         "release",
+	Short:       "Hand control back from a profile or limits set over --remote",
+	Args:        cobra.ExactArgs(0),
+	Annotations: remoteAnnotations,
+	Run: func(cmd *cobra.Command, args []string) {
+		var status daemon.Status
+		remoteCall("status", nil, &status)
+
+		if status.Override != "" {
+			remoteCall("profile.switch", api.SwitchParams{}, &status)
+			fmt.Println("released profile", status.Override)
+		}
+		if !status.Temporary.IsEmpty() {
+			remoteCall("limits.clear", nil, &status)
+			fmt.Println("released temporary limits")
+		}
+
+		renderStatus(status)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(releaseCmd)
+}
+
+// useRemote returns true if a command should go through the daemon's socket rather than
+// the MSRs: with --remote, or when we're not root (and so couldn't get at the MSRs anyway)
+// and the daemon is listening
+func useRemote() bool {
+	if remoteFlag {
+		return true
+	}
+
+	if os.Geteuid() == 0 {
+		return false
+	}
+
+	fi, err := os.Stat(socketFlag)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// checkRemote exits if --remote was given for a command that can't go through the socket
+func checkRemote(cmd *cobra.Command) {
+	if remoteFlag && cmd.Annotations[remoteAnnotation] == "" {
+		log.Fatalf("%s can't be used with --remote", cmd.CommandPath())
+	}
+}
+
+// remoteCall calls method on the daemon, exiting on failure
+func remoteCall(method string, params interface{}, result interface{}) {
+	c, err := api.Dial(socketFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Call(method, params, result); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// remoteDuration formats --for for the API
+func remoteDuration() string {
+	if remoteForFlag == 0 {
+		return ""
+	}
+	return remoteForFlag.String()
+}
+
+// setRemoteLimits sets temporary limits on the daemon and shows the result
+func setRemoteLimits(s daemon.State) {
+	var status daemon.Status
+	remoteCall("limits.set", api.LimitsParams{State: s, Duration: remoteDuration()}, &status)
+	renderStatus(status)
+}
+
+func renderStatus(s daemon.Status) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"", "value"})
+	table.SetBorder(false)
+
+	add := func(key string, value string) {
+		if value != "" {
+			table.Append([]string{key, value})
+		}
+	}
+	until := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return "until " + t.Local().Format("15:04:05")
+	}
+
+	add("profile", s.Profile)
+	add("power source", s.Source)
+	add("triggered", s.Triggered)
+	if s.Override != "" {
+		add("override", fmt.Sprintf("%s %s", s.Override, until(s.OverrideUntil)))
+	}
+	if s.State.PL1 != 0 {
+		add("pl1", fmt.Sprintf("%gW", s.State.PL1))
+	}
+	if s.Controlling {
+		add("pl1", "controlled")
+	}
+	if s.State.PL2 != 0 {
+		add("pl2", fmt.Sprintf("%gW", s.State.PL2))
+	}
+	if s.State.ThrottleTemp != 0 {
+		add("temp", fmt.Sprintf("%dC", s.State.ThrottleTemp))
+	}
+	if !s.Temporary.IsEmpty() {
+		add("temporary", fmt.Sprintf("%s %s", stateString(s.Temporary), until(s.TemporaryUntil)))
+	}
+
+	table.Render()
+}
+
+// stateString formats the settings in a daemon state
+func stateString(s daemon.State) string {
+	var settings []string
+	if s.PL1 != 0 {
+		settings = append(settings, fmt.Sprintf("pl1=%gW", s.PL1))
+	}
+	if s.PL2 != 0 {
+		settings = append(settings, fmt.Sprintf("pl2=%gW", s.PL2))
+	}
+	if s.ThrottleTemp != 0 {
+		settings = append(settings, fmt.Sprintf("temp=%dC", s.ThrottleTemp))
+	}
+
+	return strings.Join(settings, " ")
+}
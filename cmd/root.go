@@ -4,12 +4,17 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/davidr/ddtp/pkg/api"
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
 	cpuFlag     int
+	cpusFlag    string
+	configFlag  string
 	verboseFlag bool
 	debugFlag   bool
 )
@@ -30,6 +35,8 @@ var rootCmd = &cobra.Command{
 		} else {
 			log.SetLevel(log.WarnLevel)
 		}
+
+		checkRemote(cmd)
 	},
 }
 
@@ -47,6 +54,43 @@ func init() {
 	cpuDefault := 0
 
 	rootCmd.PersistentFlags().IntVarP(&cpuFlag, "cpu", "c", cpuDefault, "CPU Number (Default: 0)")
+	rootCmd.PersistentFlags().StringVar(&cpusFlag, "cpus", "", "List of CPU numbers (e.g. 0,2-3), overrides --cpu")
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", config.DefaultPath, "Config file")
 	rootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "Verbose output")
 	rootCmd.PersistentFlags().BoolVarP(&debugFlag, "debug", "d", false, "Debug output")
+	rootCmd.PersistentFlags().BoolVar(&remoteFlag, "remote", false, "Go through the daemon's socket (the default when not root and the daemon is running)")
+	rootCmd.PersistentFlags().StringVar(&socketFlag, "socket", api.DefaultSocket, "The daemon's socket, for --remote")
+}
+
+// getCPUs returns the list of CPUs a command should operate on: the --cpus list if one was
+// given, every CPU on the system if cpu is -1, or otherwise just cpu
+func getCPUs(cpu int) ([]int, error) {
+	if cpusFlag != "" {
+		return util.ParseCPUList(cpusFlag)
+	}
+
+	if cpu != -1 {
+		return []int{cpu}, nil
+	}
+
+	return util.GetAllCPUs()
+}
+
+// getPerThreadCPUs is getCPUs for settings that are per-thread rather than per-package.
+// Touching just CPU 0 is rarely what anyone wants for those, so they default to every CPU
+// unless --cpu or --cpus was given.
+func getPerThreadCPUs(cmd *cobra.Command) ([]int, error) {
+	if !cmd.Flags().Changed("cpu") && cpusFlag == "" {
+		return getCPUs(-1)
+	}
+
+	return getCPUs(cpuFlag)
+}
+
+// enabledString formats a flag for display in tables
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
 }
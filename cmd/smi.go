@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var smiCmd = &cobra.Command{
+	Use:   "smi",
+	Short: "System Management Interrupt (SMI) Counter Interface",
+}
+
+var smiWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show SMI count and rate per CPU every interval",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := watchSMI(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	addWatchFlags(smiWatchCmd)
+
+	smiCmd.AddCommand(smiWatchCmd)
+	rootCmd.AddCommand(smiCmd)
+}
+
+func watchSMI(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	sampler, err := msr.NewSMISampler(cpus)
+	if err != nil {
+		return err
+	}
+
+	return watchLoop(func() error {
+		samples, err := sampler.Sample()
+		if err != nil {
+			return err
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"CPU", "SMIs", "SMI/s"})
+		table.SetBorder(false)
+
+		for _, s := range samples {
+			table.Append([]string{strconv.Itoa(s.CPU), strconv.FormatUint(s.Count, 10), formatSMIRate(s)})
+		}
+
+		table.Render()
+		fmt.Println()
+		return nil
+	})
+}
+
+// formatSMIRate formats the rate column that the other monitoring tables share
+func formatSMIRate(s msr.SMISample) string {
+	return fmt.Sprintf("%0.1f", s.Rate)
+}
+
+// newOptionalSMISampler returns an SMI sampler for the monitors that show SMIs alongside
+// everything else, or nil if the SMI count can't be read (it isn't there under most
+// hypervisors). Only smi itself treats that as an error.
+func newOptionalSMISampler(cpus []int) *msr.SMISampler {
+	sampler, err := msr.NewSMISampler(cpus)
+	if err != nil {
+		log.Debugf("not showing SMIs: %s", err)
+		return nil
+	}
+
+	return sampler
+}
+
+// sampleOptionalSMIs samples sampler, returning nil if there's no sampler or it fails
+func sampleOptionalSMIs(sampler *msr.SMISampler) []msr.SMISample {
+	if sampler == nil {
+		return nil
+	}
+
+	samples, err := sampler.Sample()
+	if err != nil {
+		log.Debugf("could not sample SMIs: %s", err)
+		return nil
+	}
+
+	return samples
+}
+
+// formatOptionalSMIRate formats the rate for the i'th CPU, or "n/a" without samples
+func formatOptionalSMIRate(samples []msr.SMISample, i int) string {
+	if i >= len(samples) {
+		return "n/a"
+	}
+
+	return formatSMIRate(samples[i])
+}
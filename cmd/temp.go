@@ -5,13 +5,20 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/davidr/ddtp/pkg/daemon"
 	"github.com/davidr/ddtp/pkg/msr"
 	"github.com/davidr/ddtp/pkg/util"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
+var (
+	tempWindowFlag    time.Duration
+	tempWindowChanged bool
+)
+
 var tempCmd = &cobra.Command{
 	Use:   "temp",
 	Short: "Package Temperature Target Interface",
@@ -33,9 +40,10 @@ var tempListCmd = &cobra.Command{
 }
 
 var tempSetCmd = &cobra.Command{
-	Use:   "set TEMPERATURE",
-	Short: "Set throttle temperature value(s)",
-	Args:  cobra.ExactArgs(1),
+	Use:         "set TEMPERATURE",
+	Short:       "Set throttle temperature value(s)",
+	Args:        cobra.ExactArgs(1),
+	Annotations: remoteAnnotations,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Read temperature into int64 to process change
 		throttleTemp, err := strconv.Atoi(args[0])
@@ -43,11 +51,24 @@ var tempSetCmd = &cobra.Command{
 			log.Fatal("Could not parse argument into temperature: ", err)
 		}
 
-		setTemp(cpuFlag, throttleTemp)
+		tempWindowChanged = cmd.Flags().Changed("window")
+		if useRemote() {
+			if tempWindowChanged {
+				log.Fatal("--window can't be used with --remote")
+			}
+			setRemoteLimits(daemon.State{ThrottleTemp: throttleTemp})
+			return
+		}
+		if err := setTemp(cpuFlag, throttleTemp); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
 func init() {
+	tempSetCmd.Flags().DurationVar(&tempWindowFlag, "window", 0, "Average temperature over this window before throttling (e.g. 5s, 0 to turn off)")
+	tempSetCmd.Flags().DurationVar(&remoteForFlag, "for", 0, "With --remote, how long the daemon should hold the temperature (default: until released)")
+
 	tempCmd.AddCommand(tempListCmd)
 	tempCmd.AddCommand(tempSetCmd)
 	rootCmd.AddCommand(tempCmd)
@@ -70,6 +91,13 @@ func setTemp(cpu int, throttleTemp int) error {
 		return fmt.Errorf("unable to set throttling temperature: %s", err)
 	}
 
+	if tempWindowChanged {
+		fmt.Println("setting CPU", cpu, "offset window to", tempWindowFlag)
+		if err := tt.SetWindow(tempWindowFlag.Seconds()); err != nil {
+			return fmt.Errorf("unable to set offset window: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -116,7 +144,7 @@ func listTemp(cpu int) error {
 
 func listAllTemps() error {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"CPU", "Throttle Temp"})
+	table.SetHeader([]string{"CPU", "Throttle Temp", "Window", "Clamping"})
 	table.SetBorder(false)
 
 	cpus, err := util.GetAllCPUs()
@@ -131,7 +159,12 @@ func listAllTemps() error {
 			log.Fatal("Could not read temperature target data: ", err)
 		}
 
-		table.Append([]string{strconv.Itoa(cpu), strconv.Itoa(int(tt.GetThrottleTemp()))})
+		window := "off"
+		if tt.GetWindow() > 0 {
+			window = fmt.Sprintf("%gs", tt.GetWindow())
+		}
+
+		table.Append([]string{strconv.Itoa(cpu), strconv.Itoa(int(tt.GetThrottleTemp())), window, enabledString(tt.IsClamping())})
 	}
 
 	table.Render()
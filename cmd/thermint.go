@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	thresholdPackageFlag bool
+	thresholdDisableFlag bool
+)
+
+var tempThresholdCmd = &cobra.Command{
+	Use:   "threshold",
+	Short: "Thermal Interrupt Threshold Interface",
+}
+
+var tempThresholdListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List core and package thermal interrupt thresholds per CPU",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listThresholds(cmd)
+	},
+}
+
+var tempThresholdSetCmd = &cobra.Command{
+	Use:   "set 1|2 TEMPERATURE",
+	Short: "Set a thermal interrupt threshold (in C) and enable its interrupt",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatal("Could not parse threshold number: ", err)
+		}
+
+		temp, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal("Could not parse argument into temperature: ", err)
+		}
+
+		err = updateThermalInterrupts(cmd, func(ti *msr.ThermalInterrupt) error {
+			return ti.SetThreshold(n, temp, !thresholdDisableFlag)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var tempThresholdPLNCmd = &cobra.Command{
+	Use:       "pln enable|disable",
+	Short:     "Enable or disable the power limit notification interrupt",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"enable", "disable"},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := updateThermalInterrupts(cmd, func(ti *msr.ThermalInterrupt) error {
+			return ti.SetPowerLimitNotification(args[0] == "enable")
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	tempThresholdCmd.PersistentFlags().BoolVar(&thresholdPackageFlag, "package", false, "Use the package thermal interrupt register instead of the core one")
+	tempThresholdSetCmd.Flags().BoolVar(&thresholdDisableFlag, "disable", false, "Program the threshold but leave its interrupt disabled")
+
+	tempThresholdCmd.AddCommand(tempThresholdListCmd)
+	tempThresholdCmd.AddCommand(tempThresholdSetCmd)
+	tempThresholdCmd.AddCommand(tempThresholdPLNCmd)
+	tempCmd.AddCommand(tempThresholdCmd)
+}
+
+// thresholdString formats a threshold for display, e.g. "85C (enabled)"
+func thresholdString(ti *msr.ThermalInterrupt, n int) string {
+	temp, enabled := ti.GetThreshold(n)
+	return fmt.Sprintf("%dC (%s)", temp, enabledString(enabled))
+}
+
+func listThresholds(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		log.Fatal("Could not get list of CPUs: ", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CPU", "scope", "threshold 1", "threshold 2", "power limit notify"})
+	table.SetBorder(false)
+
+	for _, c := range cpus {
+		for _, pkg := range []bool{false, true} {
+			ti, err := msr.GetThermalInterrupt(c, pkg)
+			if err != nil {
+				log.Fatalf("could not read thermal interrupt on cpu %d: %s", c, err)
+			}
+
+			scope := "core"
+			if pkg {
+				scope = "package"
+			}
+
+			table.Append([]string{strconv.Itoa(c), scope, thresholdString(&ti, 1), thresholdString(&ti, 2),
+				enabledString(ti.IsPowerLimitNotificationEnabled())})
+		}
+	}
+
+	table.Render()
+	return nil
+}
+
+// updateThermalInterrupts calls update on the selected thermal interrupt register of every
+// CPU we've been asked about
+func updateThermalInterrupts(cmd *cobra.Command, update func(*msr.ThermalInterrupt) error) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		ti, err := msr.GetThermalInterrupt(c, thresholdPackageFlag)
+		if err != nil {
+			return fmt.Errorf("could not read thermal interrupt on cpu %d: %s", c, err)
+		}
+
+		if err := update(&ti); err != nil {
+			return fmt.Errorf("unable to set thermal interrupt: %s", err)
+		}
+
+		fmt.Printf("set CPU %d thresholds to %s, %s (power limit notify %s)\n", c,
+			thresholdString(&ti, 1), thresholdString(&ti, 2), enabledString(ti.IsPowerLimitNotificationEnabled()))
+	}
+
+	return nil
+}
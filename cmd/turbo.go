@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/util"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var turboCmd = &cobra.Command{
+	Use:   "turbo",
+	Short: "Turbo Ratio Limit Interface",
+}
+
+var turboListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List max turbo frequency by active core count",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listTurbo(cpuFlag)
+	},
+}
+
+var turboLimitCmd = &cobra.Command{
+	Use:   "limit FREQUENCY",
+	Short: "Cap turbo frequency for every active core count (e.g. 3.2GHz)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		mhz, err := util.ParseFrequencyMHz(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := limitTurbo(cpuFlag, mhz); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var turboOffCmd = &cobra.Command{
+	Use:   "off",
+	Short: "Disable turbo",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setTurbo(cmd, false); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var turboOnCmd = &cobra.Command{
+	Use:   "on",
+	Short: "Enable turbo",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setTurbo(cmd, true); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	turboCmd.AddCommand(turboListCmd)
+	turboCmd.AddCommand(turboLimitCmd)
+	turboCmd.AddCommand(turboOffCmd)
+	turboCmd.AddCommand(turboOnCmd)
+	rootCmd.AddCommand(turboCmd)
+}
+
+func listTurbo(cpu int) error {
+	trl, err := msr.GetTurboRatioLimit(cpu)
+	if err != nil {
+		log.Fatalf("could not read turbo ratio limits on cpu %d: %s", cpu, err)
+	}
+
+	enabled, err := msr.IsTurboEnabled(cpu)
+	if err != nil {
+		log.Fatalf("could not read turbo state on cpu %d: %s", cpu, err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorder(false)
+
+	// Without group sizes, all we can say is which group a ratio is for
+	counts := trl.GetCoreCounts()
+	if counts == nil {
+		table.SetHeader([]string{"group", "ratio", "max frequency"})
+	} else {
+		table.SetHeader([]string{"active cores", "ratio", "max frequency"})
+	}
+
+	for i, ratio := range trl.GetRatios() {
+		label := fmt.Sprintf("group %d", i)
+		if counts != nil {
+			label = activeCoresString(counts, i)
+		}
+		table.Append([]string{label, strconv.Itoa(ratio), fmt.Sprintf("%d MHz", trl.RatioToMHz(ratio))})
+	}
+
+	table.Render()
+	fmt.Println("turbo:", enabledString(enabled))
+	return nil
+}
+
+// activeCoresString formats the active core counts the i'th ratio applies to: a single
+// count, or a range when the ratio is for a group
+func activeCoresString(counts []int, i int) string {
+	first := 1
+	if i > 0 {
+		first = counts[i-1] + 1
+	}
+
+	if first == counts[i] {
+		return strconv.Itoa(first)
+	}
+	return fmt.Sprintf("%d-%d", first, counts[i])
+}
+
+func limitTurbo(cpu int, mhz int) error {
+	cpus, err := getCPUs(cpu)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		trl, err := msr.GetTurboRatioLimit(c)
+		if err != nil {
+			return fmt.Errorf("could not read turbo ratio limits on cpu %d: %s", c, err)
+		}
+
+		ratio := trl.MHzToRatio(mhz)
+		if trl.RatioToMHz(ratio) != mhz {
+			log.Warnf("%d MHz is not a multiple of %d MHz; rounding down to %d MHz", mhz, trl.GetBusClockMHz(), trl.RatioToMHz(ratio))
+		}
+
+		fmt.Println("capping CPU", c, "turbo at", trl.RatioToMHz(ratio), "MHz")
+		if err := trl.SetMaxRatio(ratio); err != nil {
+			return fmt.Errorf("unable to cap turbo ratio: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func setTurbo(cmd *cobra.Command, enabled bool) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		fmt.Println("setting CPU", c, "turbo to", enabledString(enabled))
+		if err := msr.SetTurboEnabled(c, enabled); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/util"
+	"github.com/olekukonko/tablewriter"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	uncoreMinFlag string
+	uncoreMaxFlag string
+)
+
+var uncoreCmd = &cobra.Command{
+	Use:   "uncore",
+	Short: "Uncore (ring/cache) Frequency Limit Interface",
+}
+
+var uncoreListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List uncore frequency limits and current uncore frequency",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		listUncore(cpuFlag)
+	},
+}
+
+var uncoreSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set uncore frequency limits (--min, --max, e.g. --max 2.0GHz)",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := setUncore(cmd, cpuFlag); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	uncoreSetCmd.Flags().StringVar(&uncoreMinFlag, "min", "", "Minimum uncore frequency")
+	uncoreSetCmd.Flags().StringVar(&uncoreMaxFlag, "max", "", "Maximum uncore frequency")
+
+	uncoreCmd.AddCommand(uncoreListCmd)
+	uncoreCmd.AddCommand(uncoreSetCmd)
+	rootCmd.AddCommand(uncoreCmd)
+}
+
+func listUncore(cpu int) error {
+	url, err := msr.GetUncoreRatioLimit(cpu)
+	if err != nil {
+		log.Fatalf("could not read uncore ratio limits on cpu %d: %s", cpu, err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"", "ratio", "frequency"})
+	table.SetBorder(false)
+
+	table.Append([]string{"min", strconv.Itoa(url.GetMinRatio()), fmt.Sprintf("%d MHz", url.RatioToMHz(url.GetMinRatio()))})
+	table.Append([]string{"max", strconv.Itoa(url.GetMaxRatio()), fmt.Sprintf("%d MHz", url.RatioToMHz(url.GetMaxRatio()))})
+
+	if ratio, err := msr.GetUncoreRatio(cpu); err == nil {
+		table.Append([]string{"current", strconv.Itoa(ratio), fmt.Sprintf("%d MHz", url.RatioToMHz(ratio))})
+	} else {
+		log.Infof("current uncore frequency unavailable: %s", err)
+	}
+
+	table.Render()
+	return nil
+}
+
+func setUncore(cmd *cobra.Command, cpu int) error {
+	if !cmd.Flags().Changed("min") && !cmd.Flags().Changed("max") {
+		return fmt.Errorf("nothing to set; use --min or --max")
+	}
+
+	cpus, err := getCPUs(cpu)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	for _, c := range cpus {
+		url, err := msr.GetUncoreRatioLimit(c)
+		if err != nil {
+			return fmt.Errorf("could not read uncore ratio limits on cpu %d: %s", c, err)
+		}
+
+		minRatio, maxRatio := url.GetMinRatio(), url.GetMaxRatio()
+		if cmd.Flags().Changed("min") {
+			mhz, err := util.ParseFrequencyMHz(uncoreMinFlag)
+			if err != nil {
+				return err
+			}
+			minRatio = url.MHzToRatio(mhz)
+		}
+		if cmd.Flags().Changed("max") {
+			mhz, err := util.ParseFrequencyMHz(uncoreMaxFlag)
+			if err != nil {
+				return err
+			}
+			maxRatio = url.MHzToRatio(mhz)
+		}
+
+		fmt.Println("setting CPU", c, "uncore limits to", url.RatioToMHz(minRatio), "-", url.RatioToMHz(maxRatio), "MHz")
+
+		// Write in whichever order keeps min <= max after each step
+		if maxRatio > url.GetMaxRatio() {
+			err = url.SetMaxRatio(maxRatio)
+			if err == nil {
+				err = url.SetMinRatio(minRatio)
+			}
+		} else {
+			err = url.SetMinRatio(minRatio)
+			if err == nil {
+				err = url.SetMaxRatio(maxRatio)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to set uncore ratio limits: %s", err)
+		}
+	}
+
+	return nil
+}
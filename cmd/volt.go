@@ -1,17 +1,25 @@
 package cmd
 
 import (
+	"encoding/csv"
 	"fmt"
 	"os"
 	"sort"
 	"strconv"
+	"time"
 
+	"github.com/davidr/ddtp/pkg/curve"
 	"github.com/davidr/ddtp/pkg/msr"
 	"github.com/olekukonko/tablewriter"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var (
+	curveConfig  curve.Config
+	curveCSVFlag bool
+)
+
 var voltCmd = &cobra.Command{
 	Use:   "volt",
 	Short: "Under/Overvolt Interface",
@@ -44,18 +52,75 @@ var voltListCmd = &cobra.Command{
 }
 
 var voltSetCmd = &cobra.Command{
-	Use:   "set",
-	Short: "Set plane voltage value",
+	Use:   "set PLANE MILLIVOLTS",
+	Short: "Set plane voltage offset value (in mV)",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("volt set...")
+		plane, ok := msr.VoltagePlanes[args[0]]
+		if !ok {
+			log.Fatalf("Invalid plane '%s'", args[0])
+		}
 
+		mVolts, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal("Could not parse argument into voltage offset: ", err)
+		}
+
+		fmt.Println("setting CPU", cpuFlag, args[0], "voltage offset to", mVolts, "mV")
+		if err := msr.SetVoltage(plane, mVolts, cpuFlag); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var voltNowCmd = &cobra.Command{
+	Use:   "now",
+	Short: "Show the ratio and core voltage each CPU is running at",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listCoreVoltage(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var voltWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Sample core voltage alongside effective frequency every interval",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := watchCoreVoltage(cmd); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var voltCurveCmd = &cobra.Command{
+	Use:   "curve",
+	Short: "Capture the voltage/frequency curve of a core under load",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := captureCurve(cpuFlag); err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
 func init() {
+	addWatchFlags(voltWatchCmd)
+
+	voltCurveCmd.Flags().IntVar(&curveConfig.MinRatio, "min-ratio", 0, "First ratio to measure (default: min operating ratio)")
+	voltCurveCmd.Flags().IntVar(&curveConfig.MaxRatio, "max-ratio", 0, "Last ratio to measure (default: max turbo ratio)")
+	voltCurveCmd.Flags().DurationVar(&curveConfig.Settle, "settle", 200*time.Millisecond, "Time to let each ratio settle before measuring")
+	voltCurveCmd.Flags().DurationVar(&curveConfig.Dwell, "dwell", 500*time.Millisecond, "Time to measure at each ratio")
+	voltCurveCmd.Flags().StringVar(&curveConfig.Method, "method", "auto", "How to limit frequency (auto|cpufreq|hwp)")
+	voltCurveCmd.Flags().BoolVar(&curveCSVFlag, "csv", false, "Output CSV instead of a table")
+
 	voltCmd.AddCommand(voltListCmd)
 	voltCmd.AddCommand(voltSetCmd)
+	voltCmd.AddCommand(voltNowCmd)
+	voltCmd.AddCommand(voltWatchCmd)
+	voltCmd.AddCommand(voltCurveCmd)
 	rootCmd.AddCommand(voltCmd)
 }
 
@@ -110,3 +175,114 @@ func listAllPlaneVoltage() error {
 	table.Render()
 	return nil
 }
+
+func listCoreVoltage(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"CPU", "ratio", "frequency", "voltage"})
+	table.SetBorder(false)
+
+	for _, c := range cpus {
+		ps, err := msr.GetPerfStatus(c)
+		if err != nil {
+			return err
+		}
+
+		table.Append([]string{strconv.Itoa(c), strconv.Itoa(ps.Ratio), fmt.Sprintf("%d MHz", msr.RatioToMHz(ps.Ratio)), fmt.Sprintf("%0.4f V", ps.Voltage)})
+	}
+
+	table.Render()
+	return nil
+}
+
+func watchCoreVoltage(cmd *cobra.Command) error {
+	cpus, err := getPerThreadCPUs(cmd)
+	if err != nil {
+		return fmt.Errorf("could not get list of CPUs: %s", err)
+	}
+
+	sampler, err := msr.NewFrequencySampler(cpus)
+	if err != nil {
+		return err
+	}
+
+	smiSampler := newOptionalSMISampler(cpus)
+
+	return watchLoop(func() error {
+		samples, err := sampler.Sample()
+		if err != nil {
+			return err
+		}
+
+		smiSamples := sampleOptionalSMIs(smiSampler)
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"CPU", "Busy%", "Bzy_MHz", "ratio", "voltage", "SMI/s"})
+		table.SetBorder(false)
+
+		for i, s := range samples {
+			// PERF_STATUS is instantaneous, so this is the voltage at the end of the
+			// interval rather than an average over it
+			ps, err := msr.GetPerfStatus(s.CPU)
+			if err != nil {
+				return err
+			}
+
+			table.Append([]string{
+				strconv.Itoa(s.CPU),
+				fmt.Sprintf("%0.2f", s.Busy),
+				fmt.Sprintf("%0.0f", s.BusyMHz),
+				strconv.Itoa(ps.Ratio),
+				fmt.Sprintf("%0.4f V", ps.Voltage),
+				formatOptionalSMIRate(smiSamples, i),
+			})
+		}
+
+		table.Render()
+		fmt.Println()
+		return nil
+	})
+}
+
+func captureCurve(cpu int) error {
+	if cpu < 0 {
+		return fmt.Errorf("a single CPU (--cpu) is needed to capture a curve")
+	}
+
+	curveConfig.CPU = cpu
+	points, err := curve.Capture(curveConfig)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"ratio", "requested MHz", "Bzy_MHz", "Busy%", "voltage (V)", "offset (mV)"}
+	var rows [][]string
+	for _, p := range points {
+		rows = append(rows, []string{
+			strconv.Itoa(p.Ratio),
+			strconv.Itoa(msr.RatioToMHz(p.Ratio)),
+			fmt.Sprintf("%0.0f", p.BusyMHz),
+			fmt.Sprintf("%0.1f", p.Busy),
+			fmt.Sprintf("%0.4f", p.Voltage),
+			strconv.Itoa(p.OffsetMV),
+		})
+	}
+
+	if curveCSVFlag {
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		w.WriteAll(rows)
+		return w.Error()
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetBorder(false)
+	table.AppendBulk(rows)
+	table.Render()
+	return nil
+}
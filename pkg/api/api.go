@@ -0,0 +1,96 @@
+// Package api is the daemon's control socket. Only root can get at the MSRs, so the socket
+// is how anything else (a desktop widget, a script, ddtp --remote) asks the daemon what it's
+// doing or to change it.
+//
+// The protocol is JSON-RPC 2.0, one request or response per line. Peers are identified
+// by their socket credentials: root may call anything, members of the configured group may
+// call the read-only methods, and each mutating method may also be called by the users and
+// groups in its allow list. With an allow list the socket is open to everyone, since those
+// users and groups needn't be in the api group, but anyone else is hung up on as soon as
+// they connect.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/daemon"
+)
+
+// DefaultSocket is where the socket lives unless the config says otherwise
+const DefaultSocket = "/run/ddtp.sock"
+
+// JSON-RPC error codes. The first four are from the spec, the rest are ours.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeDenied         = -32000 // the peer isn't allowed to call the method
+	CodeFailed         = -32001 // the method was called but failed
+)
+
+// Request is a JSON-RPC request. A request without an ID is a notification and gets no
+// response.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response: either Result or Error is set
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api: %s", e.Message)
+}
+
+func errorf(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ProfileInfo is a profile as returned by profile.list
+type ProfileInfo struct {
+	Name     string         `json:"name"`
+	Settings []config.Field `json:"settings"`
+}
+
+// SwitchParams are the params for profile.switch
+type SwitchParams struct {
+	Profile  string `json:"profile"`            // profile to hold; empty to go back to the usual one
+	Duration string `json:"duration,omitempty"` // how long for, e.g. "1h"; indefinitely if empty
+}
+
+// LimitsParams are the params for limits.set: any of pl1, pl2 and temp, and how long for
+type LimitsParams struct {
+	daemon.State
+	Duration string `json:"duration,omitempty"` // e.g. "30m"; until limits.clear if empty
+}
+
+// method is a method the server handles
+type method struct {
+	mutating bool
+	call     func(s *Server, params json.RawMessage) (interface{}, error)
+}
+
+// methods are the methods the server handles, by name
+var methods = map[string]method{
+	"status":         {false, (*Server).status},
+	"profile.list":   {false, (*Server).profileList},
+	"profile.switch": {true, (*Server).profileSwitch},
+	"limits.set":     {true, (*Server).limitsSet},
+	"limits.clear":   {true, (*Server).limitsClear},
+}
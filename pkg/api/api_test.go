@@ -0,0 +1,272 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/daemon"
+)
+
+func TestAuthorize(t *testing.T) {
+	s := &Server{readGID: 100, allow: map[string]principals{
+		"limits.set": {uids: map[uint32]bool{1000: true}, gids: map[uint32]bool{200: true}},
+	}}
+
+	m := []struct {
+		p       peer
+		method  string
+		allowed bool
+	}{
+		{peer{uid: 0}, "limits.set", true},
+		{peer{uid: 1001, gid: 100}, "status", true},
+		{peer{uid: 1001, gid: 1001, groups: []uint32{100}}, "status", true},
+		{peer{uid: 1001, gid: 1001}, "status", false},
+		{peer{uid: 1000, gid: 1000}, "limits.set", true},
+		{peer{uid: 1001, gid: 1001, groups: []uint32{200}}, "limits.set", true},
+		// the api group only gets the read-only methods
+		{peer{uid: 1001, gid: 100}, "limits.set", false},
+		{peer{uid: 1000, gid: 1000}, "profile.switch", false},
+	}
+
+	for _, c := range m {
+		err := s.authorize(c.p, c.method, methods[c.method])
+		if allowed := err == nil; allowed != c.allowed {
+			t.Errorf("%+v calling %s: allowed:%t, should be %t", c.p, c.method, allowed, c.allowed)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, err := config.Parse("test.yaml", []byte("profiles:\n  quiet:\n    pl1: 10\n  fast:\n    pl1: 30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := daemon.New(nil, time.Second, daemon.State{PL1: 15})
+	s, err := NewServer(d, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.socket = filepath.Join(dir, "ddtp.sock")
+	s.readGID = os.Getgid()
+
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	c, err := Dial(s.GetSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var status daemon.Status
+	if err := c.Call("status", nil, &status); err != nil || status.State.PL1 != 15 {
+		t.Errorf("status is %+v (%v), should be holding PL1 at 15W", status, err)
+	}
+
+	var profiles []ProfileInfo
+	if err := c.Call("profile.list", nil, &profiles); err != nil || len(profiles) != 2 || profiles[0].Name != "fast" {
+		t.Errorf("profiles are %+v (%v), should be fast and quiet", profiles, err)
+	}
+
+	err = c.Call("volt.set", nil, nil)
+	if e, ok := err.(*Error); !ok || e.Code != CodeMethodNotFound {
+		t.Errorf("calling a missing method gave %v, should be method not found", err)
+	}
+
+	// the daemon is holding a state rather than a profile, so there's nothing to override
+	// (and unless we're root, no allow list to be on)
+	if err := c.Call("profile.switch", SwitchParams{Profile: "fast"}, nil); err == nil {
+		t.Errorf("switched profile on a daemon without one")
+	}
+}
+
+func TestListenPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := []struct {
+		readGID int
+		allow   map[string]principals
+		mode    os.FileMode
+	}{
+		{-1, nil, 0600},
+		{os.Getgid(), nil, 0660},
+		// allowlisted users needn't be in the api group, so they need to be able to connect
+		{os.Getgid(), map[string]principals{"limits.set": {uids: map[uint32]bool{1000: true}}}, 0666},
+		{-1, map[string]principals{"limits.set": {gids: map[uint32]bool{200: true}}}, 0666},
+	}
+
+	for _, c := range m {
+		s, err := NewServer(daemon.New(nil, time.Second, daemon.State{}), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.socket = filepath.Join(dir, "ddtp.sock")
+		s.readGID = c.readGID
+		s.allow = c.allow
+
+		l, err := s.Listen()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(s.socket)
+		if err != nil || fi.Mode().Perm() != c.mode {
+			t.Errorf("socket for group %d and allow list %v has mode %v (%v), should be %v", c.readGID, c.allow, fi.Mode().Perm(), err, c.mode)
+		}
+		l.Close()
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(daemon.New(nil, time.Second, daemon.State{PL1: 15}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.socket = filepath.Join(dir, "ddtp.sock")
+	s.idleTimeout = 100 * time.Millisecond
+	s.conns = make(chan struct{}, 1)
+
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	first, err := Dial(s.GetSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := first.Call("status", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// only one connection at a time: the second is dropped
+	second, err := Dial(s.GetSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := second.Call("status", nil, nil); err == nil {
+		t.Errorf("a second connection was served with a limit of one")
+	}
+
+	// and once the first has been idle too long, it's closed and frees up its slot
+	time.Sleep(300 * time.Millisecond)
+	if err := first.Call("status", nil, nil); err == nil {
+		t.Errorf("an idle connection was still being served after the idle timeout")
+	}
+
+	third, err := Dial(s.GetSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if err := third.Call("status", nil, nil); err != nil {
+		t.Errorf("a new connection after the idle one was closed failed: %s", err)
+	}
+}
+
+func TestServeLongRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(daemon.New(nil, time.Second, daemon.State{PL1: 15}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.socket = filepath.Join(dir, "ddtp.sock")
+
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	conn, err := net.Dial("unix", s.GetSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a request that fills MaxRequestSize without a newline ends the connection
+	go conn.Write(bytes.Repeat([]byte("["), MaxRequestSize))
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil || resp.Error == nil || resp.Error.Code != CodeParseError {
+		t.Errorf("an oversized request got %+v (%v), should be a parse error", resp, err)
+	}
+}
+
+func TestServeStranger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(daemon.New(nil, time.Second, daemon.State{PL1: 15}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.socket = filepath.Join(dir, "ddtp.sock")
+	s.readGID = 100
+	s.allow = map[string]principals{"limits.set": {uids: map[uint32]bool{1000: true}}}
+	s.identify = func(net.Conn) (peer, error) {
+		return peer{uid: 4321, gid: 4321}, nil
+	}
+
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	c, err := Dial(s.GetSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// hung up on without being read from or taking a slot
+	if err := c.Call("status", nil, nil); err == nil {
+		t.Errorf("a uid that may not call anything was served")
+	}
+	if n := len(s.conns); n != 0 {
+		t.Errorf("%d connection slots in use after a stranger connected, should be none", n)
+	}
+}
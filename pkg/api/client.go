@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Client is a connection to the daemon's socket
+type Client struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	id   int
+}
+
+// Dial connects to the socket at path
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("api: could not connect to the daemon: %s", err)
+	}
+
+	return &Client{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call calls method with params (nil for none) and decodes the result into result (nil to
+// throw it away). A method that fails returns an *Error.
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	c.id++
+	req := Request{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(c.id)), Method: method}
+
+	if params != nil {
+		buf, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("api: %s", err)
+		}
+		req.Params = buf
+	}
+
+	if err := c.enc.Encode(req); err != nil {
+		return fmt.Errorf("api: %s", err)
+	}
+
+	var resp Response
+	if err := c.dec.Decode(&resp); err != nil {
+		return fmt.Errorf("api: %s", err)
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("api: %s", err)
+	}
+
+	return nil
+}
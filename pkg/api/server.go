@@ -0,0 +1,526 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/daemon"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// IdleTimeout is how long a connection may sit without sending a request (or reading a
+// response) before it's closed
+const IdleTimeout = time.Minute
+
+// MaxConns is how many connections are served at once. Any more are closed as soon as
+// they're accepted.
+const MaxConns = 32
+
+// MaxRequestSize is the longest request line the server reads. A longer one ends the
+// connection, so nobody can stream an arbitrarily large request at the daemon.
+const MaxRequestSize = 64 << 10
+
+// Server serves the API for a daemon
+type Server struct {
+	daemon *daemon.Daemon
+	cfg    *config.Config // for looking up profiles; may be nil
+	socket string
+
+	readGID int                   // group allowed the read-only methods, or -1
+	allow   map[string]principals // by method
+
+	idleTimeout time.Duration
+	conns       chan struct{} // one per connection being served
+
+	identify func(net.Conn) (peer, error) // peerCred, but tests can swap it out
+}
+
+// principals are the users and groups allowed to call a method
+type principals struct {
+	uids map[uint32]bool
+	gids map[uint32]bool
+}
+
+// peer is the process on the other end of a connection
+type peer struct {
+	uid    uint32
+	gid    uint32
+	pid    int32
+	groups []uint32 // supplementary groups
+}
+
+// NewServer returns a Server for d, with access set up from cfg's api section (root only,
+// if it doesn't have one). Users and groups are looked up now, so a typo fails here rather
+// than on the first call.
+func NewServer(d *daemon.Daemon, cfg *config.Config) (*Server, error) {
+	s := &Server{
+		daemon:      d,
+		cfg:         cfg,
+		socket:      DefaultSocket,
+		readGID:     -1,
+		allow:       make(map[string]principals),
+		idleTimeout: IdleTimeout,
+		conns:       make(chan struct{}, MaxConns),
+		identify:    peerCred,
+	}
+	if cfg == nil || cfg.API == nil {
+		return s, nil
+	}
+
+	a := cfg.API
+	if a.Socket != "" {
+		s.socket = a.Socket
+	}
+
+	if a.Group != "" {
+		gid, err := lookupGroup(a.Group)
+		if err != nil {
+			return nil, err
+		}
+		s.readGID = int(gid)
+	}
+
+	for name, who := range a.Allow {
+		m, ok := methods[name]
+		if !ok {
+			return nil, fmt.Errorf("api: unknown method '%s' in allow list", name)
+		}
+		if !m.mutating {
+			return nil, fmt.Errorf("api: %s is read-only; it's open to the api group", name)
+		}
+
+		p := principals{uids: make(map[uint32]bool), gids: make(map[uint32]bool)}
+		for _, w := range who {
+			if strings.HasPrefix(w, "@") {
+				gid, err := lookupGroup(strings.TrimPrefix(w, "@"))
+				if err != nil {
+					return nil, err
+				}
+				p.gids[gid] = true
+				continue
+			}
+
+			u, err := user.Lookup(w)
+			if err != nil {
+				return nil, fmt.Errorf("api: unknown user '%s'", w)
+			}
+			uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+			p.uids[uint32(uid)] = true
+		}
+		s.allow[name] = p
+	}
+
+	return s, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("api: unknown group '%s'", name)
+	}
+
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("api: group '%s' has a non-numeric gid", name)
+	}
+
+	return uint32(gid), nil
+}
+
+// GetSocket returns the path the server listens on
+func (s *Server) GetSocket() string {
+	return s.socket
+}
+
+// Listen creates the socket, replacing a stale one left by a previous run. With an allow
+// list, anyone may connect, since the users and groups on it needn't be in the api group;
+// Serve hangs up on anyone who isn't root, in the api group or on an allow list as soon as
+// they're identified. Otherwise it's only accessible to root and the api group, since
+// there's no need to let everyone else connect.
+func (s *Server) Listen() (net.Listener, error) {
+	if fi, err := os.Lstat(s.socket); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("api: %s exists and isn't a socket", s.socket)
+		}
+		os.Remove(s.socket)
+	}
+
+	l, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return nil, fmt.Errorf("api: %s", err)
+	}
+
+	mode := os.FileMode(0600)
+	if s.readGID >= 0 {
+		mode = 0660
+		if err := os.Chown(s.socket, -1, s.readGID); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("api: %s", err)
+		}
+	}
+	if len(s.allow) > 0 {
+		mode = 0666
+	}
+	if err := os.Chmod(s.socket, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("api: %s", err)
+	}
+
+	return l, nil
+}
+
+// Serve accepts connections on l until it's closed. At most MaxConns are served at once,
+// and each is closed after sitting idle for IdleTimeout, so that nobody who can connect can
+// tie up the daemon by holding connections open.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.admit(conn)
+	}
+}
+
+// admit identifies the peer on conn and serves it if there's a free slot. A peer that can't
+// call any method is turned away before it takes a slot or has anything read from it, so
+// that it can't crowd out the peers that can.
+func (s *Server) admit(conn net.Conn) {
+	p, err := s.identify(conn)
+	if err != nil {
+		log.Errorf("api: could not identify peer: %s", err)
+		conn.Close()
+		return
+	}
+
+	if !s.mayConnect(p) {
+		log.Warnf("api: hanging up on uid %d (pid %d), which may not call anything", p.uid, p.pid)
+		conn.Close()
+		return
+	}
+
+	select {
+	case s.conns <- struct{}{}:
+		s.serveConn(conn, p)
+		<-s.conns
+	default:
+		log.Warnf("api: already serving %d connections, dropping a new one", cap(s.conns))
+		conn.Close()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn, p peer) {
+	defer conn.Close()
+	log.Debugf("api: connection from pid %d uid %d", p.pid, p.uid)
+
+	r := bufio.NewReaderSize(conn, MaxRequestSize)
+	enc := json.NewEncoder(conn)
+	for {
+		conn.SetDeadline(time.Now().Add(s.idleTimeout))
+
+		line, err := r.ReadSlice('\n')
+		switch {
+		case err == bufio.ErrBufferFull:
+			enc.Encode(Response{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: errorf(CodeParseError, "request longer than %d bytes", MaxRequestSize)})
+			return
+		case err != nil:
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Debugf("api: closing idle connection from pid %d", p.pid)
+			}
+			return
+		}
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		// Requests are one per line, so a parse error doesn't lose track of the next one
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			if err := enc.Encode(Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errorf(CodeParseError, "%s", err)}); err != nil {
+				return
+			}
+			continue
+		}
+
+		resp := s.handle(p, req)
+		if req.ID == nil {
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// peerCred returns the credentials of the process on the other end of conn
+func peerCred(conn net.Conn) (peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peer{}, fmt.Errorf("not a unix socket")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return peer{}, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return peer{}, err
+	}
+	if credErr != nil {
+		return peer{}, credErr
+	}
+
+	p := peer{uid: cred.Uid, gid: cred.Gid, pid: cred.Pid}
+
+	// SO_PEERCRED only has the primary group
+	if u, err := user.LookupId(strconv.Itoa(int(cred.Uid))); err == nil {
+		gids, _ := u.GroupIds()
+		for _, g := range gids {
+			if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+				p.groups = append(p.groups, uint32(gid))
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// inGroup returns true if gid is the peer's primary or a supplementary group
+func (p peer) inGroup(gid uint32) bool {
+	if p.gid == gid {
+		return true
+	}
+
+	for _, g := range p.groups {
+		if g == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// include returns true if p is one of the principals
+func (a principals) include(p peer) bool {
+	if a.uids[p.uid] {
+		return true
+	}
+	for gid := range a.gids {
+		if p.inGroup(gid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayConnect returns true if p may call at least one method: it's root, in the api group,
+// or on an allow list
+func (s *Server) mayConnect(p peer) bool {
+	if p.uid == 0 || (s.readGID >= 0 && p.inGroup(uint32(s.readGID))) {
+		return true
+	}
+
+	for _, allowed := range s.allow {
+		if allowed.include(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize returns an error unless p may call the method called name
+func (s *Server) authorize(p peer, name string, m method) error {
+	if p.uid == 0 {
+		return nil
+	}
+
+	if !m.mutating {
+		if s.readGID >= 0 && p.inGroup(uint32(s.readGID)) {
+			return nil
+		}
+		return errorf(CodeDenied, "permission denied: %s needs the api group", name)
+	}
+
+	if s.allow[name].include(p) {
+		return nil
+	}
+
+	return errorf(CodeDenied, "permission denied: uid %d is not allowed to call %s", p.uid, name)
+}
+
+// handle runs a request from p and returns the response
+func (s *Server) handle(p peer, req Request) Response {
+	resp := Response{JSONRPC: "2.0", ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	fail := func(err error) Response {
+		e, ok := err.(*Error)
+		if !ok {
+			e = errorf(CodeFailed, "%s", err)
+		}
+		resp.Error = e
+		return resp
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return fail(errorf(CodeInvalidRequest, "not a JSON-RPC 2.0 request"))
+	}
+
+	m, ok := methods[req.Method]
+	if !ok {
+		return fail(errorf(CodeMethodNotFound, "no method '%s'", req.Method))
+	}
+
+	if err := s.authorize(p, req.Method, m); err != nil {
+		log.Warnf("api: denied %s to uid %d (pid %d)", req.Method, p.uid, p.pid)
+		return fail(err)
+	}
+
+	if m.mutating {
+		log.Infof("api: uid %d (pid %d) called %s %s", p.uid, p.pid, req.Method, req.Params)
+	}
+
+	result, err := m.call(s, req.Params)
+	if err != nil {
+		return fail(err)
+	}
+
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return fail(err)
+	}
+
+	resp.Result = buf
+	return resp
+}
+
+// decodeParams decodes params into v, which is left alone if there aren't any
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(params, v); err != nil {
+		return errorf(CodeInvalidParams, "invalid params: %s", err)
+	}
+
+	return nil
+}
+
+// parseUntil returns when something given a duration should end: never (the zero time) if
+// duration is empty
+func parseUntil(duration string) (time.Time, error) {
+	if duration == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 {
+		return time.Time{}, errorf(CodeInvalidParams, "invalid duration '%s'", duration)
+	}
+
+	return time.Now().Add(d), nil
+}
+
+func (s *Server) status(params json.RawMessage) (interface{}, error) {
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) profileList(params json.RawMessage) (interface{}, error) {
+	if s.cfg == nil {
+		return nil, fmt.Errorf("the daemon has no config file")
+	}
+
+	profiles := []ProfileInfo{}
+	for _, name := range s.cfg.Names() {
+		p, _ := s.cfg.GetProfile(name)
+		profiles = append(profiles, ProfileInfo{Name: name, Settings: p.Fields()})
+	}
+
+	return profiles, nil
+}
+
+func (s *Server) profileSwitch(params json.RawMessage) (interface{}, error) {
+	var sp SwitchParams
+	if err := decodeParams(params, &sp); err != nil {
+		return nil, err
+	}
+
+	until, err := parseUntil(sp.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	var p *config.Profile
+	if sp.Profile != "" {
+		if s.cfg == nil {
+			return nil, fmt.Errorf("the daemon has no config file")
+		}
+		if p, err = s.cfg.GetProfile(sp.Profile); err != nil {
+			return nil, errorf(CodeInvalidParams, "no profile named '%s'", sp.Profile)
+		}
+	}
+
+	if err := s.daemon.OverrideProfile(p, until); err != nil {
+		return nil, err
+	}
+
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) limitsSet(params json.RawMessage) (interface{}, error) {
+	var lp LimitsParams
+	if err := decodeParams(params, &lp); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case lp.State.IsEmpty():
+		return nil, errorf(CodeInvalidParams, "give at least one of pl1, pl2 and temp")
+	case lp.PL1 < 0 || lp.PL2 < 0:
+		return nil, errorf(CodeInvalidParams, "power limits must be positive")
+	case lp.ThrottleTemp < 0 || lp.ThrottleTemp > 127:
+		return nil, errorf(CodeInvalidParams, "throttle temperature %dC out of range", lp.ThrottleTemp)
+	}
+
+	until, err := parseUntil(lp.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.daemon.SetTemporaryState(lp.State, until); err != nil {
+		return nil, err
+	}
+
+	return s.daemon.Status(), nil
+}
+
+func (s *Server) limitsClear(params json.RawMessage) (interface{}, error) {
+	if err := s.daemon.ClearTemporaryState(); err != nil {
+		return nil, err
+	}
+
+	return s.daemon.Status(), nil
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/davidr/ddtp/pkg/cpufreq"
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Apply applies every setting in the profile to cpus. Package-scoped settings (voltage,
+// power limits, throttle temperature) are written through each CPU in turn, which is
+// redundant but harmless: the setters don't write values that are already in place.
+func (p *Profile) Apply(cpus []int) error {
+	log.Infof("applying profile %s to cpus %v", p.Name, cpus)
+	for _, cpu := range cpus {
+		if err := p.applyCPU(cpu); err != nil {
+			return fmt.Errorf("config: could not apply profile %s on cpu %d: %s", p.Name, cpu, err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyDiff applies only the settings that differ from the profile on each of cpus, and
+// returns what it changed. Running it twice in a row changes nothing the second time.
+// Each CPU is diffed just before it's changed, so a package-scoped setting fixed through
+// one CPU isn't rewritten through the rest.
+func (p *Profile) ApplyDiff(cpus []int) ([]Change, error) {
+	var applied []Change
+	for _, cpu := range cpus {
+		changes, err := p.diffCPU(cpu)
+		if err != nil {
+			return applied, fmt.Errorf("config: could not read back profile %s on cpu %d: %s", p.Name, cpu, err)
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		if err := p.subset(changes).applyCPU(cpu); err != nil {
+			return applied, fmt.Errorf("config: could not apply profile %s on cpu %d: %s", p.Name, cpu, err)
+		}
+
+		applied = append(applied, changes...)
+	}
+
+	return applied, nil
+}
+
+// subset returns a copy of the profile with only the settings named in changes
+func (p *Profile) subset(changes []Change) *Profile {
+	var keys []string
+	for _, c := range changes {
+		keys = append(keys, c.Key)
+	}
+
+	return p.Subset(keys)
+}
+
+// Subset returns a copy of the profile with only the settings named in keys (as in Fields)
+func (p *Profile) Subset(names []string) *Profile {
+	keys := make(map[string]bool)
+	for _, name := range names {
+		keys[name] = true
+	}
+
+	s := &Profile{Name: p.Name}
+	for plane, mv := range p.Voltage {
+		if keys["voltage."+plane] {
+			if s.Voltage == nil {
+				s.Voltage = make(map[string]int)
+			}
+			s.Voltage[plane] = mv
+		}
+	}
+	if keys["pl1"] {
+		s.PL1 = p.PL1
+	}
+	if keys["pl2"] {
+		s.PL2 = p.PL2
+	}
+	if keys["tau"] {
+		s.Tau = p.Tau
+	}
+	if keys["temp"] {
+		s.Temp = p.Temp
+	}
+	if keys["turbo"] {
+		s.Turbo = p.Turbo
+	}
+	if keys["turbo-limit"] {
+		s.TurboLimit = p.TurboLimit
+	}
+	if keys["epp"] {
+		s.EPP = p.EPP
+	}
+	if keys["epb"] {
+		s.EPB = p.EPB
+	}
+	if keys["clockmod"] {
+		s.ClockMod = p.ClockMod
+	}
+
+	return s
+}
+
+func (p *Profile) applyCPU(cpu int) error {
+	for _, plane := range sortedKeys(p.Voltage) {
+		mv := p.Voltage[plane]
+		current, err := msr.GetVoltage(msr.VoltagePlanes[plane], cpu)
+		if err != nil {
+			return err
+		}
+
+		if current == mv {
+			log.Debugf("%s voltage offset already %dmV. NOOP", plane, mv)
+			continue
+		}
+
+		log.Infof("setting %s voltage offset to %dmV on cpu %d", plane, mv, cpu)
+		if err := msr.SetVoltage(msr.VoltagePlanes[plane], mv, cpu); err != nil {
+			return err
+		}
+	}
+
+	if p.PL1 != 0 || p.PL2 != 0 || p.Tau != 0 {
+		rpl, err := msr.GetRAPLPowerLimit(cpu)
+		if err != nil {
+			return err
+		}
+
+		if p.PL1 != 0 {
+			if err := rpl.SetPowerLimit(p.PL1); err != nil {
+				return err
+			}
+		}
+		if p.PL2 != 0 {
+			if err := rpl.SetPowerLimit2(p.PL2); err != nil {
+				return err
+			}
+		}
+		if p.Tau != 0 {
+			if err := rpl.SetTimeWindow(p.Tau.Seconds()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.Temp != 0 {
+		tt, err := msr.GetTempTarget(cpu)
+		if err != nil {
+			return err
+		}
+
+		if err := tt.SetThrottleTemp(p.Temp); err != nil {
+			return err
+		}
+	}
+
+	if p.Turbo != nil {
+		if err := msr.SetTurboEnabled(cpu, *p.Turbo); err != nil {
+			return err
+		}
+	}
+
+	if p.TurboLimit != "" {
+		mhz, _ := util.ParseFrequencyMHz(p.TurboLimit)
+		trl, err := msr.GetTurboRatioLimit(cpu)
+		if err != nil {
+			return err
+		}
+
+		if err := trl.SetMaxRatio(trl.MHzToRatio(mhz)); err != nil {
+			return err
+		}
+	}
+
+	if p.EPP != "" {
+		if err := applyEPP(cpu, p.EPP); err != nil {
+			return err
+		}
+	}
+
+	if p.EPB != "" {
+		epb, _ := msr.ParseEPB(p.EPB)
+		if err := msr.SetEnergyPerfBias(cpu, epb); err != nil {
+			return err
+		}
+	}
+
+	if p.ClockMod != "" {
+		duty, _ := util.ParsePercent(p.ClockMod)
+		cm, err := msr.GetClockModulation(cpu)
+		if err != nil {
+			return err
+		}
+
+		if err := cm.SetDutyCycle(duty); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyEPP sets the energy performance preference on cpu, going through intel_pstate when
+// it's managing HWP, since it will overwrite anything written to the MSR behind its back
+func applyEPP(cpu int, epp string) error {
+	if cpufreq.HasEnergyPerformancePreference(cpu) {
+		return cpufreq.SetEnergyPerformancePreference(cpu, epp)
+	}
+
+	value, _ := msr.ParseEPP(epp)
+	req, err := msr.GetHWPRequest(cpu)
+	if err != nil {
+		return err
+	}
+
+	return req.SetEPP(value)
+}
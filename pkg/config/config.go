@@ -0,0 +1,483 @@
+// Package config reads the ddtp config file, which defines named profiles: sets of
+// settings (voltage offsets, power limits, throttle temperature, EPP, turbo...) that can be
+// applied together. A config file looks like:
+//
+//	profiles:
+//	  battery:
+//	    voltage:
+//	      cpu: -80
+//	      cache: -80
+//	    temp: 85
+//	    pl1: 15
+//	    pl2: 25
+//	    tau: 28s
+//	    epp: balance_power
+//	    turbo: false
+//
+// Every field is optional; anything a profile doesn't mention is left alone. When the daemon
+// switches profiles, it puts back what the settings an earlier profile made were before it
+// made them, so they don't outlast that profile.
+//
+// Triggers switch the daemon to a profile while a matching process is running, matched by
+// executable name or cgroup path (both globs). The highest priority trigger in effect wins,
+// and a trigger stays in effect for its linger after the last matching process exits:
+//
+//	triggers:
+//	  - profile: build
+//	    exe: [cargo, make]
+//	    priority: 10
+//	    linger: 30s
+//	  - profile: game
+//	    cgroup: ["/user.slice/*/app.slice/steam*"]
+//	    priority: 20
+//
+// The api section opens the daemon's control socket (see pkg/api) to users other than root:
+// the group may call the read-only methods, and each mutating method to the users and
+// @groups listed for it (who needn't be in the group):
+//
+//	api:
+//	  group: ddtp
+//	  allow:
+//	    profile.switch: [alice, "@wheel"]
+//	    limits.set: ["@wheel"]
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/procwatch"
+	"github.com/davidr/ddtp/pkg/util"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is where the config file lives unless told otherwise
+const DefaultPath = "/etc/ddtp.yaml"
+
+// Config is the parsed contents of a config file
+type Config struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+	Triggers []*Trigger          `yaml:"triggers"`
+	API      *API                `yaml:"api"`
+}
+
+// Profile is a named set of settings
+type Profile struct {
+	Name       string         `yaml:"-"`
+	Voltage    map[string]int `yaml:"voltage"`     // offset in mV by plane name (see msr.VoltagePlanes)
+	Temp       int            `yaml:"temp"`        // TCC throttle temperature in C
+	PL1        float64        `yaml:"pl1"`         // package power limit in W
+	PL2        float64        `yaml:"pl2"`         // short term package power limit in W
+	Tau        time.Duration  `yaml:"tau"`         // PL1 time window
+	EPP        string         `yaml:"epp"`         // energy performance preference, name or 0-255
+	EPB        string         `yaml:"epb"`         // energy performance bias, name or 0-15
+	Turbo      *bool          `yaml:"turbo"`       // turbo on or off
+	TurboLimit string         `yaml:"turbo-limit"` // max turbo frequency, e.g. 4.2GHz
+	ClockMod   string         `yaml:"clockmod"`    // clock modulation duty cycle, e.g. 50%
+
+	lines map[string]int // line each key was defined on, for error messages
+}
+
+// Trigger selects a profile while a matching process is running
+type Trigger struct {
+	Profile  string        `yaml:"profile"`
+	Exe      []string      `yaml:"exe"`      // globs matched against the executable's base name
+	Cgroup   []string      `yaml:"cgroup"`   // globs matched against the cgroup v2 path
+	Priority int           `yaml:"priority"` // highest wins when several are in effect
+	Linger   time.Duration `yaml:"linger"`   // how long to hold on after the process exits
+
+	line int
+}
+
+// API configures the daemon's control socket
+type API struct {
+	Socket string              `yaml:"socket"` // path of the socket; the api package has the default
+	Group  string              `yaml:"group"`  // group that may call the read-only methods
+	Allow  map[string][]string `yaml:"allow"`  // mutating method to the users and @groups that may call it
+
+	line int
+}
+
+// Field is a single setting in a profile, as a key and a display value
+type Field struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Load reads and validates the config file at path
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err)
+	}
+
+	return Parse(path, data)
+}
+
+// Parse parses and validates the config file contents in data. name is only used in
+// error messages.
+func Parse(name string, data []byte) (*Config, error) {
+	var cfg Config
+
+	// Decode strictly so a typo'd key is an error rather than a silently ignored setting.
+	// The yaml errors already carry line numbers.
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("config: %s: %s", name, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	// Decode again as a node tree to find out where each key was, so validation errors
+	// can point at the right line too
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("config: %s: %s", name, err)
+	}
+	lines := profileLines(&root)
+	tlines := triggerLines(&root)
+
+	var errs []string
+	for _, profileName := range cfg.Names() {
+		p := cfg.Profiles[profileName]
+		if p == nil {
+			p = &Profile{}
+			cfg.Profiles[profileName] = p
+		}
+		p.Name = profileName
+		p.lines = lines[profileName]
+
+		for _, err := range p.validate() {
+			errs = append(errs, fmt.Sprintf("%s:%d: profile %s: %s", name, err.line, profileName, err.msg))
+		}
+	}
+
+	for i, t := range cfg.Triggers {
+		if t == nil {
+			t = &Trigger{}
+			cfg.Triggers[i] = t
+		}
+		if i < len(tlines) {
+			t.line = tlines[i]
+		}
+
+		for _, msg := range t.validate(&cfg) {
+			errs = append(errs, fmt.Sprintf("%s:%d: trigger %d: %s", name, t.line, i+1, msg))
+		}
+	}
+
+	if cfg.API != nil {
+		cfg.API.line = keyLine(&root, "api")
+		for _, msg := range cfg.API.validate() {
+			errs = append(errs, fmt.Sprintf("%s:%d: api: %s", name, cfg.API.line, msg))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("config: invalid config file:\n%s", strings.Join(errs, "\n"))
+	}
+
+	return &cfg, nil
+}
+
+// profileLines returns the line each key in each profile was defined on, indexed by profile
+// name and then key. Voltage planes are keyed as "voltage.PLANE", and the profile's own line
+// is under "".
+func profileLines(root *yaml.Node) map[string]map[string]int {
+	lines := make(map[string]map[string]int)
+	if len(root.Content) == 0 {
+		return lines
+	}
+
+	profiles := mappingValue(root.Content[0], "profiles")
+	if profiles == nil {
+		return lines
+	}
+
+	for i := 0; i+1 < len(profiles.Content); i += 2 {
+		name, profile := profiles.Content[i], profiles.Content[i+1]
+		pl := map[string]int{"": name.Line}
+		lines[name.Value] = pl
+
+		for j := 0; j+1 < len(profile.Content); j += 2 {
+			key, value := profile.Content[j], profile.Content[j+1]
+			pl[key.Value] = key.Line
+
+			if key.Value == "voltage" {
+				for k := 0; k+1 < len(value.Content); k += 2 {
+					pl["voltage."+value.Content[k].Value] = value.Content[k].Line
+				}
+			}
+		}
+	}
+
+	return lines
+}
+
+// triggerLines returns the line each trigger starts on
+func triggerLines(root *yaml.Node) []int {
+	var lines []int
+	if len(root.Content) == 0 {
+		return lines
+	}
+
+	triggers := mappingValue(root.Content[0], "triggers")
+	if triggers == nil {
+		return lines
+	}
+
+	for _, trigger := range triggers.Content {
+		lines = append(lines, trigger.Line)
+	}
+
+	return lines
+}
+
+// keyLine returns the line a top level key is on, or 0
+func keyLine(root *yaml.Node, key string) int {
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return 0
+	}
+
+	top := root.Content[0]
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		if top.Content[i].Value == key {
+			return top.Content[i].Line
+		}
+	}
+
+	return 0
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+type validationError struct {
+	line int
+	msg  string
+}
+
+// validate checks every setting in the profile, returning all of the problems found
+// rather than just the first
+func (p *Profile) validate() []validationError {
+	var errs []validationError
+	fail := func(key string, format string, args ...interface{}) {
+		line, ok := p.lines[key]
+		if !ok {
+			line = p.lines[""]
+		}
+		errs = append(errs, validationError{line, fmt.Sprintf(format, args...)})
+	}
+
+	for _, plane := range sortedKeys(p.Voltage) {
+		if _, ok := msr.VoltagePlanes[plane]; !ok {
+			fail("voltage."+plane, "unknown voltage plane '%s'", plane)
+		}
+		// The offset is an 11 bit signed number in units of 1/1.024 mV
+		if mv := p.Voltage[plane]; mv < -999 || mv > 999 {
+			fail("voltage."+plane, "voltage offset %dmV out of range [-999, 999]", mv)
+		}
+	}
+
+	if p.Temp < 0 || p.Temp > 127 {
+		fail("temp", "throttle temperature %dC out of range", p.Temp)
+	}
+
+	if p.PL1 < 0 {
+		fail("pl1", "power limit must be positive")
+	}
+	if p.PL2 < 0 {
+		fail("pl2", "power limit must be positive")
+	}
+	if p.PL1 > 0 && p.PL2 > 0 && p.PL2 < p.PL1 {
+		fail("pl2", "pl2 (%gW) is lower than pl1 (%gW)", p.PL2, p.PL1)
+	}
+	if p.Tau < 0 {
+		fail("tau", "time window must be positive")
+	}
+
+	if p.EPP != "" {
+		if _, err := msr.ParseEPP(p.EPP); err != nil {
+			fail("epp", "invalid energy performance preference '%s'", p.EPP)
+		}
+	}
+
+	if p.EPB != "" {
+		if _, err := msr.ParseEPB(p.EPB); err != nil {
+			fail("epb", "invalid energy perf bias '%s'", p.EPB)
+		}
+	}
+
+	if p.TurboLimit != "" {
+		if _, err := util.ParseFrequencyMHz(p.TurboLimit); err != nil {
+			fail("turbo-limit", "%s", err)
+		}
+	}
+
+	if p.ClockMod != "" {
+		if duty, err := util.ParsePercent(p.ClockMod); err != nil || duty == 0 {
+			fail("clockmod", "invalid clock modulation duty cycle '%s'", p.ClockMod)
+		}
+	}
+
+	return errs
+}
+
+// validate checks the trigger against the config's profiles
+func (t *Trigger) validate(cfg *Config) []string {
+	var errs []string
+
+	if _, ok := cfg.Profiles[t.Profile]; !ok {
+		errs = append(errs, fmt.Sprintf("no profile named '%s'", t.Profile))
+	}
+
+	if len(t.Exe) == 0 && len(t.Cgroup) == 0 {
+		errs = append(errs, "needs at least one exe or cgroup to match")
+	}
+	for _, pattern := range append(append([]string{}, t.Exe...), t.Cgroup...) {
+		if err := procwatch.ValidatePattern(pattern); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if t.Linger < 0 {
+		errs = append(errs, "linger must be positive")
+	}
+
+	return errs
+}
+
+// validate checks the parts of the API config that don't need the api package. The method
+// names in Allow are checked when the server starts.
+func (a *API) validate() []string {
+	var errs []string
+
+	if a.Socket != "" && !filepath.IsAbs(a.Socket) {
+		errs = append(errs, fmt.Sprintf("socket path '%s' is not absolute", a.Socket))
+	}
+
+	var methods []string
+	for method := range a.Allow {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	for _, method := range methods {
+		for _, who := range a.Allow[method] {
+			if who == "" || who == "@" {
+				errs = append(errs, fmt.Sprintf("empty user or group allowed for %s", method))
+			}
+		}
+	}
+
+	return errs
+}
+
+// Rules returns the triggers as rules for a procwatch.Watcher
+func (c *Config) Rules() []procwatch.Rule {
+	var rules []procwatch.Rule
+	for _, t := range c.Triggers {
+		rules = append(rules, procwatch.Rule{Profile: t.Profile, Exe: t.Exe, Cgroup: t.Cgroup, Priority: t.Priority, Linger: t.Linger})
+	}
+
+	return rules
+}
+
+// Names returns the names of the profiles in the config, sorted
+func (c *Config) Names() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile returns the profile called name
+func (c *Config) GetProfile(name string) (*Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("config: no profile named '%s'", name)
+	}
+
+	return p, nil
+}
+
+// Fields returns the settings the profile makes, in the order they're applied
+func (p *Profile) Fields() []Field {
+	var fields []Field
+	add := func(key string, value string) {
+		fields = append(fields, Field{key, value})
+	}
+
+	for _, plane := range sortedKeys(p.Voltage) {
+		add("voltage."+plane, fmt.Sprintf("%dmV", p.Voltage[plane]))
+	}
+	if p.PL1 != 0 {
+		add("pl1", fmt.Sprintf("%gW", p.PL1))
+	}
+	if p.PL2 != 0 {
+		add("pl2", fmt.Sprintf("%gW", p.PL2))
+	}
+	if p.Tau != 0 {
+		add("tau", p.Tau.String())
+	}
+	if p.Temp != 0 {
+		add("temp", fmt.Sprintf("%dC", p.Temp))
+	}
+	if p.Turbo != nil {
+		add("turbo", fmt.Sprintf("%t", *p.Turbo))
+	}
+	if p.TurboLimit != "" {
+		add("turbo-limit", p.TurboLimit)
+	}
+	if p.EPP != "" {
+		add("epp", p.EPP)
+	}
+	if p.EPB != "" {
+		add("epb", p.EPB)
+	}
+	if p.ClockMod != "" {
+		add("clockmod", p.ClockMod)
+	}
+
+	return fields
+}
+
+// Keys returns the keys of the settings the profile makes, in the order Fields lists them
+func (p *Profile) Keys() []string {
+	var keys []string
+	for _, f := range p.Fields() {
+		keys = append(keys, f.Key)
+	}
+
+	return keys
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/sim"
+)
+
+const testConfig = `profiles:
+  battery:
+    voltage:
+      cpu: -80
+      cache: -80
+    temp: 85
+    pl1: 15
+    pl2: 25
+    tau: 28s
+    epp: balance_power
+    turbo: false
+  ac:
+    pl1: 45
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse("test.yaml", []byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := cfg.Names(); len(names) != 2 || names[0] != "ac" || names[1] != "battery" {
+		t.Errorf("profile names are %v, should be [ac battery]", names)
+	}
+
+	p, err := cfg.GetProfile("battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Voltage["cpu"] != -80 || p.Temp != 85 || p.PL2 != 25 || p.Tau != 28*time.Second || p.Turbo == nil || *p.Turbo {
+		t.Errorf("battery profile parsed as %+v", p)
+	}
+
+	if fields := p.Fields(); len(fields) != 8 || fields[0].Key != "voltage.cache" {
+		t.Errorf("battery profile has fields %v", fields)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	m := map[string]string{
+		// typo'd key
+		"profiles:\n  ac:\n    pl3: 45\n": "line 3",
+		// bad values, reported against the line they're on
+		"profiles:\n  ac:\n    pl1: 45\n    epp: fast\n":          "test.yaml:4: profile ac: invalid energy performance preference",
+		"profiles:\n  ac:\n    voltage:\n      core: -50\n":       "test.yaml:4: profile ac: unknown voltage plane",
+		"profiles:\n  ac:\n    pl1: 45\n    pl2: 30\n":            "test.yaml:4: profile ac: pl2 (30W) is lower than pl1 (45W)",
+		"profiles:\n  ac:\n    turbo-limit: fast\n    temp: -1\n": "test.yaml:3: profile ac: invalid frequency",
+		// triggers are numbered from 1
+		"profiles:\n  ac:\n    pl1: 45\ntriggers:\n  - profile: ac\n    exe: [cargo]\n  - profile: build\n    exe: [make]\n": "test.yaml:7: trigger 2: no profile named 'build'",
+		"profiles:\n  ac:\n    pl1: 45\ntriggers:\n  - profile: ac\n    priority: 1\n":                                       "test.yaml:5: trigger 1: needs at least one exe or cgroup",
+		"profiles:\n  ac:\n    pl1: 45\ntriggers:\n  - profile: ac\n    cgroup: [\"[\"]\n":                                   "invalid pattern '['",
+		"profiles:\n  ac:\n    pl1: 45\napi:\n  socket: ddtp.sock\n":                                                         "test.yaml:4: api: socket path 'ddtp.sock' is not absolute",
+	}
+
+	for config, want := range m {
+		_, err := Parse("test.yaml", []byte(config))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parsing %q gave error %v, should contain %q", config, err, want)
+		}
+	}
+}
+
+func TestSameEPP(t *testing.T) {
+	if !sameEPP("balance_power", "192") || sameEPP("power", "performance") {
+		t.Errorf("EPP names and numbers don't compare by value")
+	}
+
+	// sysfs can report names we don't know, like "default"
+	if sameEPP("default", "performance") || !sameEPP("default", "default") {
+		t.Errorf("unknown EPP names don't compare as strings")
+	}
+}
+
+func TestSubset(t *testing.T) {
+	cfg, err := Parse("test.yaml", []byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := cfg.GetProfile("battery")
+
+	s := p.subset([]Change{{Key: "voltage.cpu"}, {Key: "pl2"}, {Key: "turbo"}})
+	fields := s.Fields()
+	if len(fields) != 3 || fields[0].Key != "voltage.cpu" || fields[1].Key != "pl2" || fields[2].Key != "turbo" {
+		t.Errorf("subset has fields %v, should be voltage.cpu, pl2 and turbo", fields)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s, err := sim.New(sim.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetRegister(0x1b0, 6) // EPB normal
+	prev := msr.SetBackend(s)
+	defer msr.SetBackend(prev)
+
+	// the sim has no clock modulation, so that one's left out
+	p, err := Snapshot("saved", 0, []string{"pl1", "pl2", "temp", "epb", "clockmod"})
+	if err == nil || !strings.Contains(err.Error(), "clockmod") {
+		t.Errorf("snapshot of a missing register gave %v, should name clockmod", err)
+	}
+	if p.PL1 != 15 || p.PL2 != 25 || p.Temp != 100 || p.EPB != "normal" || p.ClockMod != "" {
+		t.Errorf("snapshot is %+v, should be pl1 15W, pl2 25W, temp 100C, epb normal", p)
+	}
+
+	// and it reads back in as the same profile
+	cfg, err := Parse("test.yaml", []byte("profiles:\n"+indent(p.YAML())))
+	if err != nil {
+		t.Fatalf("snapshot doesn't parse: %s\n%s", err, p.YAML())
+	}
+	parsed, _ := cfg.GetProfile("saved")
+	if parsed.PL1 != 15 || parsed.PL2 != 25 || parsed.Temp != 100 || parsed.EPB != "normal" {
+		t.Errorf("snapshot parsed back as %+v", parsed)
+	}
+
+	// applying it puts back what it saw
+	if err := msr.SetEnergyPerfBias(0, 15); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Apply([]int{0}); err != nil {
+		t.Fatal(err)
+	}
+	if epb, _ := msr.GetEnergyPerfBias(0); epb != 6 {
+		t.Errorf("applying the snapshot left epb at %d, should be 6", epb)
+	}
+}
+
+func indent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i := range lines {
+		if lines[i] != "" {
+			lines[i] = "  " + lines[i]
+		}
+	}
+	return strings.Join(lines, "")
+}
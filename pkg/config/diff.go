@@ -0,0 +1,245 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/cpufreq"
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Change is a setting whose current value on a CPU differs from the profile's
+type Change struct {
+	CPU int
+	Key string
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("cpu %d: %s %s -> %s", c.CPU, c.Key, c.Old, c.New)
+}
+
+// Diff reads back every setting in the profile on cpus and returns the ones that don't
+// match. Values are compared at the granularity the hardware stores them in, so a profile
+// asking for 25.1W isn't forever 0.025W away from the 25.125W the register can hold.
+func (p *Profile) Diff(cpus []int) ([]Change, error) {
+	var changes []Change
+	for _, cpu := range cpus {
+		c, err := p.diffCPU(cpu)
+		if err != nil {
+			return changes, fmt.Errorf("config: could not read back profile %s on cpu %d: %s", p.Name, cpu, err)
+		}
+
+		changes = append(changes, c...)
+	}
+
+	return changes, nil
+}
+
+func (p *Profile) diffCPU(cpu int) ([]Change, error) {
+	var changes []Change
+	fields := make(map[string]string)
+	for _, f := range p.Fields() {
+		fields[f.Key] = f.Value
+	}
+
+	differs := func(key string, old string) {
+		changes = append(changes, Change{cpu, key, old, fields[key]})
+	}
+
+	for _, plane := range sortedKeys(p.Voltage) {
+		current, err := msr.GetVoltage(msr.VoltagePlanes[plane], cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		if current != p.Voltage[plane] {
+			differs("voltage."+plane, fmt.Sprintf("%dmV", current))
+		}
+	}
+
+	if p.PL1 != 0 || p.PL2 != 0 || p.Tau != 0 {
+		rpl, err := msr.GetRAPLPowerLimit(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		units := rpl.GetPowerUnits()
+		if pl1, enabled := rpl.GetPowerLimit(); p.PL1 != 0 && (!enabled || math.Abs(pl1-p.PL1) > units/2) {
+			differs("pl1", powerLimitString(pl1, enabled))
+		}
+		if pl2, enabled := rpl.GetPowerLimit2(); p.PL2 != 0 && (!enabled || math.Abs(pl2-p.PL2) > units/2) {
+			differs("pl2", powerLimitString(pl2, enabled))
+		}
+		if tau := rpl.GetTimeWindow(); p.Tau != 0 && tau != rpl.RoundTimeWindow(p.Tau.Seconds()) {
+			differs("tau", time.Duration(tau*float64(time.Second)).String())
+		}
+	}
+
+	if p.Temp != 0 {
+		tt, err := msr.GetTempTarget(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		if tt.GetThrottleTemp() != p.Temp {
+			differs("temp", fmt.Sprintf("%dC", tt.GetThrottleTemp()))
+		}
+	}
+
+	if p.Turbo != nil {
+		enabled, err := msr.IsTurboEnabled(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		if enabled != *p.Turbo {
+			differs("turbo", fmt.Sprintf("%t", enabled))
+		}
+	}
+
+	if p.TurboLimit != "" {
+		trl, err := msr.GetTurboRatioLimit(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		// The limit only caps, so any ratio at or below it is as good as it gets
+		mhz, _ := util.ParseFrequencyMHz(p.TurboLimit)
+		for _, ratio := range trl.GetRatios() {
+			if ratio > trl.MHzToRatio(mhz) {
+				differs("turbo-limit", fmt.Sprintf("%dMHz", trl.RatioToMHz(trl.GetRatios()[0])))
+				break
+			}
+		}
+	}
+
+	if p.EPP != "" {
+		current, err := readEPP(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		if !sameEPP(current, p.EPP) {
+			differs("epp", current)
+		}
+	}
+
+	if p.EPB != "" {
+		current, err := msr.GetEnergyPerfBias(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		if epb, _ := msr.ParseEPB(p.EPB); current != epb {
+			differs("epb", epbString(current))
+		}
+	}
+
+	if p.ClockMod != "" {
+		cm, err := msr.GetClockModulation(cpu)
+		if err != nil {
+			return changes, err
+		}
+
+		// Anything within half a step is what we'd have programmed
+		step := 12.5
+		if cm.IsExtended() {
+			step = 6.25
+		}
+
+		if duty, _ := util.ParsePercent(p.ClockMod); math.Abs(cm.GetDutyCycle()-duty) >= step/2 {
+			differs("clockmod", fmt.Sprintf("%g%%", cm.GetDutyCycle()))
+		}
+	}
+
+	return changes, nil
+}
+
+// Verify returns an error listing every setting in the profile that doesn't match what's
+// on cpus
+func (p *Profile) Verify(cpus []int) error {
+	changes, err := p.Diff(cpus)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+
+	return fmt.Errorf("config: profile %s did not stick:\n%s", p.Name, strings.Join(lines, "\n"))
+}
+
+// ApplyAndVerify applies the profile and reads it back, trying up to attempts times (with
+// delay in between) until everything matches. Firmware tends to be busy for a moment after
+// resume or a power source change, and can undo a write made too soon.
+func (p *Profile) ApplyAndVerify(cpus []int, attempts int, delay time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			log.Infof("profile %s did not stick, retrying in %s: %s", p.Name, delay, err)
+			time.Sleep(delay)
+		}
+
+		if err = p.Apply(cpus); err != nil {
+			continue
+		}
+
+		if err = p.Verify(cpus); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// readEPP returns the energy performance preference on cpu from wherever Apply would have
+// written it
+func readEPP(cpu int) (string, error) {
+	if cpufreq.HasEnergyPerformancePreference(cpu) {
+		return cpufreq.GetEnergyPerformancePreference(cpu)
+	}
+
+	req, err := msr.GetHWPRequest(cpu)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d", req.GetEPP()), nil
+}
+
+// sameEPP compares EPP values that may be names or numbers
+func sameEPP(a string, b string) bool {
+	va, errA := msr.ParseEPP(a)
+	vb, errB := msr.ParseEPP(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return va == vb
+}
+
+func epbString(epb int) string {
+	if name := msr.EPBName(epb); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%d", epb)
+}
+
+func powerLimitString(watts float64, enabled bool) string {
+	if !enabled {
+		return fmt.Sprintf("%gW (disabled)", watts)
+	}
+	return fmt.Sprintf("%gW", watts)
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+)
+
+// SnapshotKeys are the settings Snapshot can read back, in the order Fields lists them
+var SnapshotKeys = snapshotKeys()
+
+func snapshotKeys() []string {
+	var keys []string
+	for _, plane := range sortedKeys(msr.VoltagePlanes) {
+		keys = append(keys, "voltage."+plane)
+	}
+
+	return append(keys, "pl1", "pl2", "tau", "temp", "turbo", "turbo-limit", "epp", "epb", "clockmod")
+}
+
+// Snapshot reads the current values of the settings named in keys (see SnapshotKeys) on
+// cpu into a profile called name, which applied later puts them back. Settings that can't
+// be read are left out, and listed in the error that comes back alongside the profile.
+//
+// The turbo limit is the exception to putting things back: limits can only be lowered, so
+// a snapshot taken before a lower one was applied won't raise it again.
+func Snapshot(name string, cpu int, keys []string) (*Profile, error) {
+	p := &Profile{Name: name}
+	return p, p.Record(cpu, keys)
+}
+
+// Record is Snapshot into an existing profile: it reads the current values of the settings
+// named in keys on cpu into p, replacing whatever p had for them and leaving the rest alone
+func (p *Profile) Record(cpu int, keys []string) error {
+	var failed []string
+	for _, key := range keys {
+		if err := p.snapshotKey(cpu, key); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", key, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("config: could not read back on cpu %d: %s", cpu, strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func (p *Profile) snapshotKey(cpu int, key string) error {
+	if strings.HasPrefix(key, "voltage.") {
+		plane := strings.TrimPrefix(key, "voltage.")
+		if _, ok := msr.VoltagePlanes[plane]; !ok {
+			return fmt.Errorf("unknown voltage plane '%s'", plane)
+		}
+
+		mv, err := msr.GetVoltage(msr.VoltagePlanes[plane], cpu)
+		if err != nil {
+			return err
+		}
+
+		if p.Voltage == nil {
+			p.Voltage = make(map[string]int)
+		}
+		p.Voltage[plane] = mv
+		return nil
+	}
+
+	switch key {
+	case "pl1", "pl2", "tau":
+		rpl, err := msr.GetRAPLPowerLimit(cpu)
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "pl1":
+			p.PL1, _ = rpl.GetPowerLimit()
+		case "pl2":
+			p.PL2, _ = rpl.GetPowerLimit2()
+		case "tau":
+			p.Tau = time.Duration(rpl.GetTimeWindow() * float64(time.Second))
+		}
+
+	case "temp":
+		tt, err := msr.GetTempTarget(cpu)
+		if err != nil {
+			return err
+		}
+		p.Temp = tt.GetThrottleTemp()
+
+	case "turbo":
+		enabled, err := msr.IsTurboEnabled(cpu)
+		if err != nil {
+			return err
+		}
+		p.Turbo = &enabled
+
+	case "turbo-limit":
+		trl, err := msr.GetTurboRatioLimit(cpu)
+		if err != nil {
+			return err
+		}
+		if len(trl.GetRatios()) == 0 {
+			return fmt.Errorf("no turbo ratios")
+		}
+		p.TurboLimit = fmt.Sprintf("%dMHz", trl.RatioToMHz(trl.GetRatios()[0]))
+
+	case "epp":
+		epp, err := readEPP(cpu)
+		if err != nil {
+			return err
+		}
+		p.EPP = epp
+
+	case "epb":
+		epb, err := msr.GetEnergyPerfBias(cpu)
+		if err != nil {
+			return err
+		}
+		p.EPB = epbString(epb)
+
+	case "clockmod":
+		cm, err := msr.GetClockModulation(cpu)
+		if err != nil {
+			return err
+		}
+		p.ClockMod = fmt.Sprintf("%g%%", cm.GetDutyCycle())
+
+	default:
+		return fmt.Errorf("unknown setting")
+	}
+
+	return nil
+}
+
+// YAML returns the profile as it would be written in the profiles section of a config file
+func (p *Profile) YAML() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:\n", p.Name)
+
+	if len(p.Voltage) > 0 {
+		b.WriteString("  voltage:\n")
+		for _, plane := range sortedKeys(p.Voltage) {
+			fmt.Fprintf(&b, "    %s: %d\n", plane, p.Voltage[plane])
+		}
+	}
+
+	// Everything else is a scalar, so Fields has the keys (and the order); only the values
+	// need writing the way the parser reads them rather than for display
+	values := map[string]string{
+		"pl1":         fmt.Sprintf("%g", p.PL1),
+		"pl2":         fmt.Sprintf("%g", p.PL2),
+		"tau":         p.Tau.String(),
+		"temp":        fmt.Sprintf("%d", p.Temp),
+		"turbo-limit": fmt.Sprintf("%q", p.TurboLimit),
+		"epp":         fmt.Sprintf("%q", p.EPP),
+		"epb":         fmt.Sprintf("%q", p.EPB),
+		"clockmod":    fmt.Sprintf("%q", p.ClockMod),
+	}
+	for _, f := range p.Fields() {
+		if strings.HasPrefix(f.Key, "voltage.") {
+			continue
+		}
+
+		value, ok := values[f.Key]
+		if !ok {
+			value = f.Value
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", f.Key, value)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,204 @@
+// Package control holds the package at a target temperature by adjusting PL1. A fixed PL1
+// is either too loose (the fans roar) or too tight (everything is slow); a PID controller
+// lets the CPU use whatever headroom the cooling actually has.
+package control
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	log "github.com/sirupsen/logrus"
+)
+
+// bindingFraction is how close to PL1 the package has to be drawing for PL1 to count as
+// the thing holding it back. Below that, raising PL1 does nothing, so the controller
+// mustn't wind up trying.
+const bindingFraction = 0.9
+
+// Config is the controller's setpoint, bounds and tuning
+type Config struct {
+	CPU      int           // CPU to read and write the package registers through
+	Target   float64       // package temperature to hold, in C
+	MinPL1   float64       // W
+	MaxPL1   float64       // W
+	Kp       float64       // W per C of error
+	Ki       float64       // W per C of error per s
+	Kd       float64       // W per C/s the temperature is changing
+	MaxRate  float64       // largest change to PL1 in W/s
+	Interval time.Duration // how often to update PL1
+}
+
+// Validate checks the config makes sense
+func (c Config) Validate() error {
+	switch {
+	case c.Target <= 0:
+		return fmt.Errorf("control: target temperature must be positive")
+	case c.MinPL1 <= 0 || c.MaxPL1 < c.MinPL1:
+		return fmt.Errorf("control: PL1 bounds [%gW, %gW] are invalid", c.MinPL1, c.MaxPL1)
+	case c.Kp < 0 || c.Ki < 0 || c.Kd < 0:
+		return fmt.Errorf("control: gains must not be negative")
+	case c.MaxRate <= 0:
+		return fmt.Errorf("control: rate limit must be positive")
+	case c.Interval <= 0:
+		return fmt.Errorf("control: interval must be positive")
+	}
+
+	return nil
+}
+
+// Decision is one update of the controller, for logging
+type Decision struct {
+	Temp        float64 // C
+	Power       float64 // W drawn over the last interval
+	P, I, D     float64 // the terms that went into Output
+	Output      float64 // new PL1 in W
+	Saturated   bool    // Output was clamped to the PL1 bounds
+	RateLimited bool    // Output was clamped by the rate limit
+}
+
+func (d Decision) String() string {
+	flags := ""
+	if d.Saturated {
+		flags += " saturated"
+	}
+	if d.RateLimited {
+		flags += " rate-limited"
+	}
+
+	return fmt.Sprintf("%.1fC, %.1fW drawn: PL1 %.2fW [p %.2f i %.2f d %.2f%s]", d.Temp, d.Power, d.Output, d.P, d.I, d.D, flags)
+}
+
+// PID is a PID controller whose output is PL1
+type PID struct {
+	cfg      Config
+	integral float64
+	output   float64
+	lastTemp float64
+	primed   bool
+}
+
+// NewPID returns a PID controller starting from a PL1 of initial W. The integral term starts
+// there too, so taking over from an existing limit doesn't cause a jump.
+func NewPID(cfg Config, initial float64) *PID {
+	initial = math.Max(cfg.MinPL1, math.Min(cfg.MaxPL1, initial))
+	return &PID{cfg: cfg, integral: initial, output: initial}
+}
+
+// Update takes the package temperature and the power it drew over the last dt, and returns
+// the new PL1
+func (p *PID) Update(temp float64, power float64, dt time.Duration) Decision {
+	c := p.cfg
+	seconds := dt.Seconds()
+	e := c.Target - temp // positive when there's headroom
+
+	d := Decision{Temp: temp, Power: power}
+	d.P = c.Kp * e
+
+	// Derivative on the measurement rather than the error, so it doesn't kick if the
+	// target changes
+	if p.primed && seconds > 0 {
+		d.D = -c.Kd * (temp - p.lastTemp) / seconds
+	}
+
+	// Anti-windup by conditional integration: don't accumulate error that can't do
+	// anything, i.e. pushing further past a bound, or pushing PL1 up when the package isn't
+	// drawing anywhere near it anyway
+	integral := p.integral + c.Ki*e*seconds
+	raw := d.P + integral + d.D
+	windingUp := e > 0 && (raw > c.MaxPL1 || power < bindingFraction*p.output)
+	windingDown := e < 0 && raw < c.MinPL1
+	if !windingUp && !windingDown {
+		p.integral = math.Max(c.MinPL1, math.Min(c.MaxPL1, integral))
+	}
+	d.I = p.integral
+
+	out := d.P + d.I + d.D
+	if out > c.MaxPL1 || out < c.MinPL1 {
+		out = math.Max(c.MinPL1, math.Min(c.MaxPL1, out))
+		d.Saturated = true
+	}
+
+	if step := c.MaxRate * seconds; math.Abs(out-p.output) > step {
+		out = p.output + math.Copysign(step, out-p.output)
+		d.RateLimited = true
+	}
+
+	d.Output = out
+	p.output = out
+	p.lastTemp = temp
+	p.primed = true
+	return d
+}
+
+// Controller runs a PID against the package registers
+type Controller struct {
+	cfg     Config
+	pid     *PID
+	sampler *msr.PackagePowerSampler
+	last    time.Time
+}
+
+// New returns a Controller for cfg, starting from the current PL1
+func New(cfg Config) (*Controller, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	rpl, err := msr.GetRAPLPowerLimit(cfg.CPU)
+	if err != nil {
+		return nil, fmt.Errorf("control: could not read power limit: %s", err)
+	}
+
+	sampler, err := msr.NewPackagePowerSampler(cfg.CPU)
+	if err != nil {
+		return nil, fmt.Errorf("control: %s", err)
+	}
+
+	pl1, _ := rpl.GetPowerLimit()
+	return &Controller{cfg: cfg, pid: NewPID(cfg, pl1), sampler: sampler, last: msr.Now()}, nil
+}
+
+// GetInterval returns how often Step should be called
+func (c *Controller) GetInterval() time.Duration {
+	return c.cfg.Interval
+}
+
+// Step reads the package temperature and power, updates the PID and writes the new PL1 if
+// it's moved by at least one power unit
+func (c *Controller) Step() (Decision, error) {
+	now := msr.Now()
+	dt := now.Sub(c.last)
+	c.last = now
+
+	temp, err := msr.GetPackageTemperature(c.cfg.CPU)
+	if err != nil {
+		return Decision{}, fmt.Errorf("control: could not read package temperature: %s", err)
+	}
+
+	power, err := c.sampler.Sample()
+	if err != nil {
+		return Decision{}, fmt.Errorf("control: %s", err)
+	}
+
+	d := c.pid.Update(float64(temp), power, dt)
+
+	rpl, err := msr.GetRAPLPowerLimit(c.cfg.CPU)
+	if err != nil {
+		return d, fmt.Errorf("control: could not read power limit: %s", err)
+	}
+
+	pl1, enabled := rpl.GetPowerLimit()
+	if enabled && math.Abs(pl1-d.Output) < rpl.GetPowerUnits() {
+		log.Debugf("control: %s", d)
+		return d, nil
+	}
+
+	log.Infof("control: %s (was %.2fW)", d, pl1)
+	if err := rpl.SetPowerLimit(d.Output); err != nil {
+		return d, fmt.Errorf("control: could not set PL1: %s", err)
+	}
+
+	return d, nil
+}
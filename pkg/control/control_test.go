@@ -0,0 +1,55 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+var testConfig = Config{Target: 80, MinPL1: 5, MaxPL1: 30, Kp: 1, Ki: 0.5, MaxRate: 100, Interval: time.Second}
+
+func TestPIDDirection(t *testing.T) {
+	pid := NewPID(testConfig, 15)
+
+	if d := pid.Update(90, 15, time.Second); d.Output >= 15 {
+		t.Errorf("PL1 went from 15W to %.2fW at 10C over target", d.Output)
+	}
+
+	pid = NewPID(testConfig, 15)
+	if d := pid.Update(70, 15, time.Second); d.Output <= 15 {
+		t.Errorf("PL1 went from 15W to %.2fW at 10C under target", d.Output)
+	}
+}
+
+func TestPIDAntiWindup(t *testing.T) {
+	pid := NewPID(testConfig, 15)
+
+	// cool and idle: PL1 isn't what's holding the package back, so the integral mustn't grow
+	for i := 0; i < 100; i++ {
+		pid.Update(50, 2, time.Second)
+	}
+	if pid.integral != 15 {
+		t.Errorf("integral wound up to %.2f while the package was idle", pid.integral)
+	}
+
+	// hot and pinned at the lower bound: the integral stays within bounds
+	for i := 0; i < 100; i++ {
+		pid.Update(100, 5, time.Second)
+	}
+	if pid.integral < testConfig.MinPL1 {
+		t.Errorf("integral wound down to %.2f, below the minimum PL1", pid.integral)
+	}
+	if d := pid.Update(100, 5, time.Second); !d.Saturated || d.Output != testConfig.MinPL1 {
+		t.Errorf("PL1 is %.2fW (saturated:%t), should be pinned at %.2fW", d.Output, d.Saturated, testConfig.MinPL1)
+	}
+}
+
+func TestPIDRateLimit(t *testing.T) {
+	cfg := testConfig
+	cfg.MaxRate = 2
+	pid := NewPID(cfg, 25)
+
+	d := pid.Update(100, 25, 500*time.Millisecond)
+	if !d.RateLimited || d.Output != 24 {
+		t.Errorf("PL1 is %.2fW (rate limited:%t), should be 24W", d.Output, d.RateLimited)
+	}
+}
@@ -0,0 +1,120 @@
+package cpufreq
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SysfsRoot is the directory containing the cpuN/cpufreq directories. It's a variable so
+// that it can be pointed at a fake tree for testing.
+var SysfsRoot = "/sys/devices/system/cpu"
+
+const eppFile = "energy_performance_preference"
+
+// cpufreqPath returns the path to a file in cpu's cpufreq directory
+func cpufreqPath(cpu int, file string) string {
+	return filepath.Join(SysfsRoot, fmt.Sprintf("cpu%d", cpu), "cpufreq", file)
+}
+
+func readCpufreqFile(cpu int, file string) (string, error) {
+	path := cpufreqPath(cpu, file)
+	log.Debugf("reading %s", path)
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(buf)), nil
+}
+
+func writeCpufreqFile(cpu int, file string, value string) error {
+	path := cpufreqPath(cpu, file)
+	log.Debugf("writing '%s' to %s", value, path)
+
+	return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
+// HasEnergyPerformancePreference returns true if the cpufreq driver for cpu exposes EPP
+// through sysfs (i.e. intel_pstate is active and HWP is enabled)
+func HasEnergyPerformancePreference(cpu int) bool {
+	_, err := os.Stat(cpufreqPath(cpu, eppFile))
+	return err == nil
+}
+
+// GetEnergyPerformancePreference returns the EPP for cpu as the driver reports it. This is
+// either one of the names in msr.EPPValues, "default", or a number.
+func GetEnergyPerformancePreference(cpu int) (string, error) {
+	return readCpufreqFile(cpu, eppFile)
+}
+
+// SetEnergyPerformancePreference sets the EPP for cpu through the cpufreq driver. Newer
+// kernels accept a raw number as well as the names.
+func SetEnergyPerformancePreference(cpu int, epp string) error {
+	err := writeCpufreqFile(cpu, eppFile, epp)
+	if err != nil {
+		return fmt.Errorf("cpufreq: could not set energy performance preference on cpu %d: %s", cpu, err)
+	}
+
+	return nil
+}
+
+// HasScalingLimits returns true if cpu has a cpufreq policy whose limits we can change
+func HasScalingLimits(cpu int) bool {
+	_, err := os.Stat(cpufreqPath(cpu, "scaling_max_freq"))
+	return err == nil
+}
+
+// GetScalingLimits returns the min and max frequencies (in kHz, as sysfs has them) of the
+// cpufreq policy for cpu
+func GetScalingLimits(cpu int) (int, int, error) {
+	var limits []int
+
+	for _, file := range []string{"scaling_min_freq", "scaling_max_freq"} {
+		value, err := readCpufreqFile(cpu, file)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		khz, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cpufreq: could not parse %s '%s': %s", file, value, err)
+		}
+		limits = append(limits, khz)
+	}
+
+	return limits[0], limits[1], nil
+}
+
+// SetScalingLimits sets the min and max frequencies (in kHz) of the cpufreq policy for cpu.
+// The kernel rejects a min above the current max (and vice versa), so the order of the
+// writes depends on which direction we're moving.
+func SetScalingLimits(cpu int, minKHz int, maxKHz int) error {
+	if minKHz > maxKHz {
+		return fmt.Errorf("cpufreq: min frequency %d kHz is above max frequency %d kHz", minKHz, maxKHz)
+	}
+
+	_, curMax, err := GetScalingLimits(cpu)
+	if err != nil {
+		return err
+	}
+
+	writes := [][2]string{{"scaling_min_freq", strconv.Itoa(minKHz)}, {"scaling_max_freq", strconv.Itoa(maxKHz)}}
+	if maxKHz > curMax {
+		writes[0], writes[1] = writes[1], writes[0]
+	}
+
+	for _, w := range writes {
+		if err := writeCpufreqFile(cpu, w[0], w[1]); err != nil {
+			return fmt.Errorf("cpufreq: could not set %s on cpu %d: %s", w[0], cpu, err)
+		}
+	}
+
+	return nil
+}
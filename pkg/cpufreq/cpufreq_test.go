@@ -0,0 +1,39 @@
+package cpufreq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnergyPerformancePreference(t *testing.T) {
+	root, err := ioutil.TempDir("", "cpufreq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	SysfsRoot = root
+	os.MkdirAll(filepath.Join(root, "cpu0", "cpufreq"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "cpu0", "cpufreq", eppFile), []byte("balance_performance\n"), 0644)
+
+	if !HasEnergyPerformancePreference(0) {
+		t.Errorf("cpu0 should have an energy performance preference")
+	}
+	if HasEnergyPerformancePreference(1) {
+		t.Errorf("cpu1 should not have an energy performance preference")
+	}
+
+	epp, err := GetEnergyPerformancePreference(0)
+	if err != nil || epp != "balance_performance" {
+		t.Errorf("read epp '%s' (%v), should be balance_performance", epp, err)
+	}
+
+	if err := SetEnergyPerformancePreference(0, "power"); err != nil {
+		t.Fatal(err)
+	}
+	if epp, _ := GetEnergyPerformancePreference(0); epp != "power" {
+		t.Errorf("read epp '%s' after setting it to power", epp)
+	}
+}
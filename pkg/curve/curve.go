@@ -0,0 +1,305 @@
+// Package curve captures the voltage/frequency curve a CPU is actually running by pinning a
+// load to one core, stepping its frequency limits through each ratio and reading back
+// voltage and effective frequency at each step.
+package curve
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/cpufreq"
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Methods lists the ways Capture can limit the CPU to a single ratio
+var Methods = []string{"auto", "cpufreq", "hwp"}
+
+// ErrInterrupted is returned by Capture when it's stopped by SIGINT or SIGTERM
+var ErrInterrupted = errors.New("curve: interrupted")
+
+// Config describes a V/F curve capture
+type Config struct {
+	CPU      int
+	MinRatio int           // first ratio to step to; 0 for the platform's min operating ratio
+	MaxRatio int           // last ratio; 0 for the max single core turbo ratio
+	Settle   time.Duration // how long to let each ratio settle before measuring
+	Dwell    time.Duration // how long to measure at each ratio
+	Method   string        // one of Methods
+}
+
+// Point is a single measurement on the V/F curve
+type Point struct {
+	Ratio    int     // ratio we limited the CPU to
+	BusyMHz  float64 // effective frequency while running the load
+	Busy     float64 // percent of the dwell the core was in C0 (should be ~100)
+	Voltage  float64 // mean core voltage over the dwell in V
+	OffsetMV int     // core plane voltage offset in effect
+}
+
+// ratioLimiter pins a CPU to a single ratio and puts things back the way it found them
+type ratioLimiter interface {
+	set(ratio int) error
+	restore() error
+}
+
+type cpufreqLimiter struct {
+	cpu            int
+	minKHz, maxKHz int
+}
+
+func newCpufreqLimiter(cpu int) (*cpufreqLimiter, error) {
+	minKHz, maxKHz, err := cpufreq.GetScalingLimits(cpu)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cpufreqLimiter{cpu: cpu, minKHz: minKHz, maxKHz: maxKHz}, nil
+}
+
+func (l *cpufreqLimiter) set(ratio int) error {
+	khz := msr.RatioToMHz(ratio) * 1000
+	return cpufreq.SetScalingLimits(l.cpu, khz, khz)
+}
+
+func (l *cpufreqLimiter) restore() error {
+	return cpufreq.SetScalingLimits(l.cpu, l.minKHz, l.maxKHz)
+}
+
+type hwpLimiter struct {
+	req      msr.HWPRequest
+	min, max int
+}
+
+func newHWPLimiter(cpu int) (*hwpLimiter, error) {
+	enabled, err := msr.IsHWPEnabled(cpu)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, fmt.Errorf("HWP is not enabled on cpu %d", cpu)
+	}
+
+	req, err := msr.GetHWPRequest(cpu)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hwpLimiter{req: req, min: req.GetMinPerf(), max: req.GetMaxPerf()}, nil
+}
+
+func (l *hwpLimiter) setRange(min int, max int) error {
+	// keep min <= max after each write
+	if max > l.req.GetMaxPerf() {
+		if err := l.req.SetMaxPerf(max); err != nil {
+			return err
+		}
+		return l.req.SetMinPerf(min)
+	}
+
+	if err := l.req.SetMinPerf(min); err != nil {
+		return err
+	}
+	return l.req.SetMaxPerf(max)
+}
+
+func (l *hwpLimiter) set(ratio int) error {
+	return l.setRange(ratio, ratio)
+}
+
+func (l *hwpLimiter) restore() error {
+	return l.setRange(l.min, l.max)
+}
+
+func newRatioLimiter(cpu int, method string) (ratioLimiter, error) {
+	switch method {
+	case "cpufreq":
+		return newCpufreqLimiter(cpu)
+	case "hwp":
+		return newHWPLimiter(cpu)
+	case "auto", "":
+		// cpufreq first: if intel_pstate is running HWP, it will undo our MSR writes
+		if cpufreq.HasScalingLimits(cpu) {
+			return newCpufreqLimiter(cpu)
+		}
+		return newHWPLimiter(cpu)
+	}
+
+	return nil, fmt.Errorf("curve: unknown method '%s'", method)
+}
+
+// spin runs a busy loop pinned to cpu until stop is closed
+func spin(cpu int, started chan<- error, stop <-chan struct{}) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := util.SetCPUAffinity(cpu); err != nil {
+		started <- err
+		return
+	}
+	started <- nil
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// Capture steps cfg.CPU through each ratio from cfg.MinRatio to cfg.MaxRatio under load and
+// returns a Point for each. The CPU's frequency limits are restored before returning, even
+// on error. SIGINT and SIGTERM are caught for the length of the capture, so that a Ctrl-C
+// stops it (with ErrInterrupted and the points so far) rather than leaving the CPU pinned
+// to whatever ratio it had got to.
+func Capture(cfg Config) ([]Point, error) {
+	interrupt := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warnf("caught %s, restoring frequency limits on cpu %d", sig, cfg.CPU)
+			close(interrupt)
+		case <-done:
+		}
+	}()
+
+	return capture(cfg, interrupt)
+}
+
+// capture is Capture, stopping early when interrupt is closed
+func capture(cfg Config, interrupt <-chan struct{}) (points []Point, err error) {
+	if err := resolveRatios(&cfg); err != nil {
+		return nil, err
+	}
+
+	offset, err := msr.GetVoltage(msr.VoltagePlanes["cpu"], cfg.CPU)
+	if err != nil {
+		return nil, fmt.Errorf("curve: could not read core voltage offset: %s", err)
+	}
+
+	limiter, err := newRatioLimiter(cfg.CPU, cfg.Method)
+	if err != nil {
+		return nil, fmt.Errorf("curve: could not limit frequency on cpu %d: %s", cfg.CPU, err)
+	}
+	defer func() {
+		if rerr := limiter.restore(); rerr != nil {
+			log.Errorf("could not restore frequency limits on cpu %d: %s", cfg.CPU, rerr)
+			if err == nil {
+				err = rerr
+			}
+		}
+	}()
+
+	started := make(chan error)
+	stop := make(chan struct{})
+	go spin(cfg.CPU, started, stop)
+	defer close(stop)
+	if err := <-started; err != nil {
+		return nil, fmt.Errorf("curve: could not pin load to cpu %d: %s", cfg.CPU, err)
+	}
+
+	for ratio := cfg.MinRatio; ratio <= cfg.MaxRatio; ratio++ {
+		log.Infof("measuring cpu %d at ratio %d", cfg.CPU, ratio)
+		if err := limiter.set(ratio); err != nil {
+			return points, fmt.Errorf("curve: could not limit cpu %d to ratio %d: %s", cfg.CPU, ratio, err)
+		}
+
+		select {
+		case <-time.After(cfg.Settle):
+		case <-interrupt:
+			return points, ErrInterrupted
+		}
+
+		point, err := measure(cfg, ratio, interrupt)
+		if err != nil {
+			return points, err
+		}
+
+		point.OffsetMV = offset
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+// resolveRatios fills in the default ratio range
+func resolveRatios(cfg *Config) error {
+	if cfg.MinRatio == 0 {
+		pi, err := msr.GetPlatformInfo(cfg.CPU)
+		if err != nil {
+			return fmt.Errorf("curve: could not read platform info: %s", err)
+		}
+		cfg.MinRatio = pi.GetMinOperatingRatio()
+	}
+
+	if cfg.MaxRatio == 0 {
+		trl, err := msr.GetTurboRatioLimit(cfg.CPU)
+		if err != nil || len(trl.GetRatios()) == 0 {
+			return fmt.Errorf("curve: could not read max turbo ratio: %v", err)
+		}
+		cfg.MaxRatio = trl.GetRatios()[0]
+	}
+
+	if cfg.MinRatio > cfg.MaxRatio {
+		return fmt.Errorf("curve: min ratio %d is above max ratio %d", cfg.MinRatio, cfg.MaxRatio)
+	}
+
+	return nil
+}
+
+// measure samples voltage repeatedly over the dwell and takes the effective frequency across
+// the whole of it
+func measure(cfg Config, ratio int, interrupt <-chan struct{}) (Point, error) {
+	point := Point{Ratio: ratio}
+
+	start, err := msr.ReadFrequencyCounters(cfg.CPU)
+	if err != nil {
+		return point, err
+	}
+
+	var voltageSum float64
+	var voltageSamples int
+	for deadline := time.Now().Add(cfg.Dwell); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		select {
+		case <-interrupt:
+			return point, ErrInterrupted
+		default:
+		}
+
+		ps, err := msr.GetPerfStatus(cfg.CPU)
+		if err != nil {
+			return point, err
+		}
+
+		voltageSum += ps.Voltage
+		voltageSamples++
+	}
+
+	end, err := msr.ReadFrequencyCounters(cfg.CPU)
+	if err != nil {
+		return point, err
+	}
+
+	sample := msr.CalcFrequencySample(start, end)
+	point.BusyMHz = sample.BusyMHz
+	point.Busy = sample.Busy
+	if voltageSamples > 0 {
+		point.Voltage = voltageSum / float64(voltageSamples)
+	}
+
+	log.Debugf("ratio %d: %0.0f MHz at %0.4f V (busy %0.1f%%)", ratio, point.BusyMHz, point.Voltage, point.Busy)
+	return point, nil
+}
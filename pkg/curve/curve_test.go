@@ -0,0 +1,109 @@
+package curve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/sim"
+)
+
+const (
+	pmEnable   = 0x770
+	hwpRequest = 0x774
+)
+
+// recorder is a simulated package that keeps every HWP request written to it
+type recorder struct {
+	*sim.Sim
+	requests []uint64
+}
+
+func (r *recorder) WriteMSR(cpu int, reg int64, value uint64) error {
+	if reg == hwpRequest {
+		r.requests = append(r.requests, value)
+	}
+	return r.Sim.WriteMSR(cpu, reg, value)
+}
+
+// newRecorder returns a recorder with HWP enabled and a request of min 8, max 40, with the
+// msr package pointed at it, and a func to undo that
+func newRecorder(t *testing.T) (*recorder, func()) {
+	s, err := sim.New(sim.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything capture reads that the sim doesn't model
+	s.SetRegister(0x10, 0)         // TSC
+	s.SetRegister(0xe7, 0)         // MPERF
+	s.SetRegister(0xe8, 0)         // APERF
+	s.SetRegister(0x150, 0)        // voltage offset
+	s.SetRegister(0x198, 0x1c0000) // perf status
+	s.SetRegister(pmEnable, 1)
+	s.SetRegister(hwpRequest, 0x2808)
+
+	r := &recorder{Sim: s}
+	prev := msr.SetBackend(r)
+	return r, func() { msr.SetBackend(prev) }
+}
+
+func TestCaptureSteps(t *testing.T) {
+	r, restore := newRecorder(t)
+	defer restore()
+
+	cfg := Config{CPU: 0, MinRatio: 10, MaxRatio: 12, Method: "hwp"}
+	points, err := capture(cfg, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 3 || points[0].Ratio != 10 || points[2].Ratio != 12 {
+		t.Errorf("captured %+v, should be ratios 10 to 12", points)
+	}
+
+	// each ratio pins min and max to it, then the original request comes back
+	pinned := map[uint64]bool{}
+	for _, req := range r.requests {
+		if req&0xff == (req>>8)&0xff {
+			pinned[req&0xff] = true
+		}
+	}
+	for ratio := uint64(10); ratio <= 12; ratio++ {
+		if !pinned[ratio] {
+			t.Errorf("cpu was never pinned to ratio %d; requests were %x", ratio, r.requests)
+		}
+	}
+
+	if buf, _ := r.ReadMSR(0, hwpRequest); buf != 0x2808 {
+		t.Errorf("HWP request left at 0x%x, should be restored to 0x2808", buf)
+	}
+}
+
+func TestCaptureInterrupted(t *testing.T) {
+	r, restore := newRecorder(t)
+	defer restore()
+
+	// interrupt while the first ratio is settling
+	interrupt := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(interrupt)
+	}()
+
+	cfg := Config{CPU: 0, MinRatio: 10, MaxRatio: 12, Settle: time.Minute, Method: "hwp"}
+	points, err := capture(cfg, interrupt)
+	if err != ErrInterrupted {
+		t.Errorf("interrupted capture returned %v, should be ErrInterrupted", err)
+	}
+	if len(points) != 0 {
+		t.Errorf("interrupted capture measured %+v, should have measured nothing", points)
+	}
+
+	if len(r.requests) < 2 || r.requests[0]&0xff != 10 {
+		t.Errorf("requests were %x, should pin ratio 10 and then restore", r.requests)
+	}
+	if buf, _ := r.ReadMSR(0, hwpRequest); buf != 0x2808 {
+		t.Errorf("HWP request left at 0x%x, should be restored to 0x2808", buf)
+	}
+}
@@ -0,0 +1,561 @@
+// Package daemon holds a set of CPUs to a desired state. Firmware and the EC have a habit of
+// quietly resetting power limits and the TCC offset (on AC plug, resume, or just on a
+// timer), so a one-shot write doesn't stick. The daemon periodically reads the registers
+// back and re-applies anything that's drifted.
+package daemon
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/control"
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/power"
+	"github.com/davidr/ddtp/pkg/procwatch"
+	"github.com/davidr/ddtp/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// State is the set of settings the daemon holds the CPUs to. Zero values are left alone.
+type State struct {
+	PL1          float64 `json:"pl1,omitempty"`  // package power limit in W
+	PL2          float64 `json:"pl2,omitempty"`  // short term package power limit in W
+	ThrottleTemp int     `json:"temp,omitempty"` // TCC throttle temperature in C
+}
+
+// IsEmpty returns true if the state doesn't ask for anything to be enforced
+func (s State) IsEmpty() bool {
+	return s == State{}
+}
+
+// Correction records a setting that had drifted and was re-applied
+type Correction struct {
+	CPU      int
+	Register string
+	Old      string
+	New      string
+}
+
+func (c Correction) String() string {
+	return fmt.Sprintf("cpu %d: %s %s -> %s", c.CPU, c.Register, c.Old, c.New)
+}
+
+// Daemon periodically enforces a State on a set of CPUs
+type Daemon struct {
+	cpus     []int
+	interval time.Duration
+
+	// packages has one CPU from each package in cpus. The enforced settings are all
+	// package-scoped, so checking them through every thread would only repeat the same
+	// reads (and report the same drift) once per thread; profiles still go to all of cpus.
+	packages []int
+
+	state State
+
+	// active is the profile being held, if any. Its state is what gets enforced, and the
+	// whole profile is re-applied on resume.
+	active *config.Profile
+
+	// When no trigger is in effect, the daemon holds base (from NewProfile), or with auto
+	// set (NewAuto), the "ac" or "battery" profile from profiles depending on the power
+	// source
+	base     *config.Profile
+	auto     bool
+	profiles *config.Config
+	source   debouncer
+
+	// When triggers is set, a running process matching one of them overrides base with
+	// the triggered profile
+	triggers  *procwatch.Watcher
+	triggered string
+
+	// When controller is set, it owns PL1 and the daemon leaves it alone
+	controller *control.Controller
+
+	// original has the settings the daemon's profiles make as they were before the daemon
+	// first changed them. A profile that doesn't make one of them gets it put back, rather
+	// than holding whatever the last profile left (a triggered profile's higher PL1, say).
+	original *config.Profile
+
+	// Set over the API: a profile to hold over triggers and the power source, and settings
+	// laid over the held state. Each lasts until its until, or indefinitely if that's zero.
+	// restore has what the temporary settings replaced, to put back when they end.
+	override       *config.Profile
+	overrideUntil  time.Time
+	temporary      State
+	temporaryUntil time.Time
+	restore        State
+
+	// mu is held while the daemon acts, so the API can't change things under it
+	mu sync.Mutex
+
+	sleep   sleepDetector
+	lastErr string
+}
+
+// New returns a Daemon that holds cpus to state, checking every interval
+func New(cpus []int, interval time.Duration, state State) *Daemon {
+	return &Daemon{cpus: cpus, packages: util.GetPackageCPUs(cpus), interval: interval, state: state}
+}
+
+// NewProfile returns a Daemon that applies p to cpus and then holds its power limits and
+// throttle temperature
+func NewProfile(cpus []int, interval time.Duration, p *config.Profile) *Daemon {
+	return &Daemon{cpus: cpus, packages: util.GetPackageCPUs(cpus), interval: interval, active: p, base: p,
+		state: profileState(p)}
+}
+
+// NewAuto returns a Daemon that applies the "ac" or "battery" profile from cfg to cpus
+// depending on the power source, and holds that profile's power limits and throttle
+// temperature in between. A change of power source has to stick for debounce before the
+// daemon switches profiles, so a flaky connector doesn't thrash the settings.
+func NewAuto(cpus []int, interval time.Duration, cfg *config.Config, debounce time.Duration) (*Daemon, error) {
+	for _, name := range []string{power.AC, power.Battery} {
+		if _, err := cfg.GetProfile(name); err != nil {
+			return nil, fmt.Errorf("daemon: automatic switching needs an '%s' profile", name)
+		}
+	}
+
+	return &Daemon{cpus: cpus, packages: util.GetPackageCPUs(cpus), interval: interval, auto: true, profiles: cfg,
+		source: debouncer{delay: debounce}}, nil
+}
+
+// SetController hands PL1 over to c, which the daemon steps every c.GetInterval(). Any PL1
+// in the daemon's state or profiles is then ignored: the controller starts from whatever PL1
+// was in place when it was created.
+func (d *Daemon) SetController(c *control.Controller) {
+	d.controller = c
+}
+
+// SetTriggers switches to the profile named by the highest priority of cfg's triggers while
+// a matching process is running, and back to the daemon's own profile once none are. It only
+// makes sense for daemons from NewProfile or NewAuto, which have a profile to go back to.
+func (d *Daemon) SetTriggers(cfg *config.Config) error {
+	if d.base == nil && !d.auto {
+		return fmt.Errorf("daemon: triggers need a profile to fall back on")
+	}
+	if len(cfg.Triggers) == 0 {
+		return fmt.Errorf("daemon: no triggers in config")
+	}
+
+	d.profiles = cfg
+	d.triggers = procwatch.NewWatcher(cfg.Rules())
+	return nil
+}
+
+// profileState returns the part of a profile the daemon polls for drift
+func profileState(p *config.Profile) State {
+	return State{PL1: p.PL1, PL2: p.PL2, ThrottleTemp: p.Temp}
+}
+
+const (
+	// pollInterval is how often the daemon checks for resume and power source changes
+	pollInterval = time.Second
+
+	// applyAttempts and applyRetryDelay bound how hard the daemon tries to make a profile
+	// stick when it applies one
+	applyAttempts   = 3
+	applyRetryDelay = time.Second
+)
+
+// Run enforces the state immediately and then every interval until stop is closed. Errors
+// don't stop the daemon (the next pass may well succeed), but they're logged, once per
+// distinct error so a locked register doesn't flood the log.
+//
+// A daemon holding a profile applies it up front (for NewAuto, whichever one matches the
+// power source, and with triggers, whichever one they select), and re-applies it in full
+// when the system resumes from suspend, since voltage offsets and power limits don't
+// survive it.
+func (d *Daemon) Run(stop <-chan struct{}) {
+	if _, _, err := d.sleep.check(); err != nil {
+		log.Errorf("daemon: could not read clocks, resume won't be detected: %s", err)
+	}
+
+	d.mu.Lock()
+
+	if d.auto {
+		d.source.current = d.readPowerSource()
+	}
+	if d.triggers != nil {
+		d.triggered = d.checkTriggers(time.Now())
+	}
+	if d.auto || d.base != nil {
+		d.switchProfile(d.wantedProfile())
+	}
+
+	log.Infof("daemon: enforcing %+v on cpus %v every %s", d.state, d.packages, d.interval)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+
+	var controlTick <-chan time.Time
+	if d.controller != nil {
+		controlTicker := time.NewTicker(d.controller.GetInterval())
+		defer controlTicker.Stop()
+		controlTick = controlTicker.C
+	}
+
+	d.enforce()
+	d.mu.Unlock()
+
+	for {
+		var act func()
+		select {
+		case <-stop:
+			log.Infof("daemon: stopping")
+			return
+		case now := <-poll.C:
+			act = func() {
+				if d.poll(now) {
+					d.enforce()
+				}
+			}
+		case <-controlTick:
+			act = func() {
+				_, err := d.controller.Step()
+				d.logError(err)
+			}
+		case <-ticker.C:
+			act = d.enforce
+		}
+
+		d.mu.Lock()
+		act()
+		d.mu.Unlock()
+	}
+}
+
+// enforce runs Enforce and logs any error
+func (d *Daemon) enforce() {
+	_, err := d.Enforce()
+	d.logError(err)
+}
+
+// poll checks for resume, power source changes and triggers, re-applying or switching
+// profiles as needed. It returns true if it did anything, so the caller can enforce the
+// state straight away.
+func (d *Daemon) poll(now time.Time) bool {
+	slept, resumed, err := d.sleep.check()
+	d.logError(err)
+
+	if resumed {
+		log.Infof("daemon: resumed after %s asleep", slept.Round(time.Second))
+
+		// The power source may well have changed while we were out, and there's no
+		// point debouncing a change that happened during suspend
+		if d.auto {
+			d.source = debouncer{delay: d.source.delay, current: d.readPowerSource()}
+		}
+		if d.auto || d.base != nil {
+			d.switchProfile(d.wantedProfile())
+		}
+
+		return true
+	}
+
+	if d.auto {
+		source, err := power.GetSource()
+		if err != nil {
+			d.logError(fmt.Errorf("could not read power source: %s", err))
+		} else if d.source.update(source, now) {
+			log.Infof("daemon: power source changed to %s", source)
+		}
+	}
+
+	if d.triggers != nil {
+		d.triggered = d.checkTriggers(now)
+	}
+
+	expired := d.expire(now)
+	if d.active == nil || d.active.Name == d.wantedProfile() {
+		return expired
+	}
+
+	d.switchProfile(d.wantedProfile())
+	return true
+}
+
+// wantedProfile returns the name of the profile the daemon should be holding right now
+func (d *Daemon) wantedProfile() string {
+	switch {
+	case d.override != nil:
+		return d.override.Name
+	case d.triggered != "":
+		return d.triggered
+	case d.auto:
+		return d.source.current
+	default:
+		return d.base.Name
+	}
+}
+
+// checkTriggers scans the running processes and returns the profile the triggers select,
+// or "" if none are in effect. If the scan fails, the triggered profile stays as it is.
+func (d *Daemon) checkTriggers(now time.Time) string {
+	procs, err := procwatch.Scan()
+	if err != nil {
+		d.logError(fmt.Errorf("could not scan processes: %s", err))
+		return d.triggered
+	}
+
+	m, ok := d.triggers.Update(procs, now)
+	switch {
+	case ok && m.Rule.Profile != d.triggered:
+		log.Infof("daemon: %s (pid %d) triggered profile %s", m.Process.Exe, m.Process.PID, m.Rule.Profile)
+	case !ok && d.triggered != "":
+		log.Infof("daemon: trigger for profile %s no longer in effect", d.triggered)
+	}
+
+	return m.Rule.Profile
+}
+
+// readPowerSource returns the current power source, falling back to AC if it can't be read
+func (d *Daemon) readPowerSource() string {
+	source, err := power.GetSource()
+	if err != nil {
+		log.Errorf("daemon: could not read power source, assuming ac: %s", err)
+		return power.AC
+	}
+
+	return source
+}
+
+// switchProfile makes the profile named name the active one and applies it
+func (d *Daemon) switchProfile(name string) {
+	switch {
+	case d.override != nil && d.override.Name == name:
+		d.active = d.override
+	case d.base != nil && d.base.Name == name:
+		d.active = d.base
+	default:
+		// Trigger and auto profile names are checked up front
+		d.active, _ = d.profiles.GetProfile(name)
+	}
+	d.state = profileState(d.active)
+	d.restoreOriginal()
+	d.applyProfile()
+}
+
+// restoreOriginal records the settings the active profile makes that no earlier profile
+// has, before it changes them, and puts back the ones earlier profiles changed that it
+// doesn't make. Limits can only ever be lowered, so a turbo limit isn't raised back.
+func (d *Daemon) restoreOriginal() {
+	if len(d.cpus) == 0 {
+		return
+	}
+	if d.original == nil {
+		d.original = &config.Profile{Name: "original"}
+	}
+
+	// PL1 is the controller's to hold, whatever the profiles say
+	owned := func(key string) bool {
+		return key == "pl1" && d.controller != nil
+	}
+
+	makes := make(map[string]bool)
+	for _, key := range d.active.Keys() {
+		makes[key] = true
+	}
+
+	recorded := make(map[string]bool)
+	var unset []string
+	for _, key := range d.original.Keys() {
+		recorded[key] = true
+		if !makes[key] && !owned(key) {
+			unset = append(unset, key)
+		}
+	}
+
+	var unrecorded []string
+	for _, key := range d.active.Keys() {
+		if !recorded[key] && !owned(key) {
+			unrecorded = append(unrecorded, key)
+		}
+	}
+	if err := d.original.Record(d.cpus[0], unrecorded); err != nil {
+		log.Errorf("daemon: %s", err)
+	}
+
+	if len(unset) == 0 {
+		return
+	}
+
+	log.Infof("daemon: putting back %s, which profile %s doesn't set", strings.Join(unset, ", "), d.active.Name)
+	if err := d.original.Subset(unset).Apply(d.cpus); err != nil {
+		log.Errorf("daemon: %s", err)
+	}
+}
+
+// applyProfile applies the active profile and checks that it stuck
+func (d *Daemon) applyProfile() {
+	p := d.active
+	if d.controller != nil && p.PL1 != 0 {
+		// Writing the profile's PL1 over the controller's would only jump PL1 about: the
+		// controller puts its own output straight back on its next step
+		withoutPL1 := *p
+		withoutPL1.PL1 = 0
+		p = &withoutPL1
+	}
+
+	log.Infof("daemon: applying profile %s", d.active.Name)
+	if err := p.ApplyAndVerify(d.cpus, applyAttempts, applyRetryDelay); err != nil {
+		log.Errorf("daemon: %s", err)
+		return
+	}
+
+	log.Infof("daemon: profile %s applied and verified", d.active.Name)
+}
+
+// logError logs err unless it's the same as the last one logged
+func (d *Daemon) logError(err error) {
+	switch {
+	case err == nil:
+		d.lastErr = ""
+	case err.Error() != d.lastErr:
+		log.Errorf("daemon: %s", err)
+		d.lastErr = err.Error()
+	}
+}
+
+// debouncer tracks a value that has to hold steady for delay before it's accepted
+type debouncer struct {
+	delay   time.Duration
+	current string
+	pending string
+	since   time.Time
+}
+
+// update records value as seen at now, and returns true if it has just become the current
+// value, having been seen continuously for delay
+func (b *debouncer) update(value string, now time.Time) bool {
+	if value == b.current {
+		b.pending = ""
+		return false
+	}
+
+	if value != b.pending {
+		b.pending = value
+		b.since = now
+	}
+
+	if now.Sub(b.since) < b.delay {
+		return false
+	}
+
+	b.current = value
+	b.pending = ""
+	return true
+}
+
+// Enforce makes one pass over the packages, re-applying anything that's drifted from the
+// desired state. It returns the corrections made and the first error encountered; an error
+// on one CPU or register doesn't stop the others from being checked.
+func (d *Daemon) Enforce() ([]Correction, error) {
+	return d.enforceState(d.enforcedState())
+}
+
+// enforceState is Enforce for an explicit state
+func (d *Daemon) enforceState(state State) ([]Correction, error) {
+	var corrections []Correction
+	var firstErr error
+
+	for _, cpu := range d.packages {
+		for _, enforce := range []func(int, State) ([]Correction, error){d.enforcePowerLimits, d.enforceThrottleTemp} {
+			c, err := enforce(cpu, state)
+			corrections = append(corrections, c...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	for _, c := range corrections {
+		log.Warnf("daemon: corrected %s", c)
+	}
+
+	return corrections, firstErr
+}
+
+// drifted returns true if the limit read back doesn't match watts to within the register's
+// granularity, or has been disabled
+func drifted(current float64, enabled bool, watts float64, units float64) bool {
+	return !enabled || math.Abs(current-watts) > units/2
+}
+
+func (d *Daemon) enforcePowerLimits(cpu int, state State) ([]Correction, error) {
+	if (state.PL1 == 0 || d.controller != nil) && state.PL2 == 0 {
+		return nil, nil
+	}
+
+	rpl, err := msr.GetRAPLPowerLimit(cpu)
+	if err != nil {
+		return nil, fmt.Errorf("could not read power limits on cpu %d: %s", cpu, err)
+	}
+
+	var corrections []Correction
+
+	if state.PL1 != 0 && d.controller == nil {
+		old, enabled := rpl.GetPowerLimit()
+		if drifted(old, enabled, state.PL1, rpl.GetPowerUnits()) {
+			if err := rpl.SetPowerLimit(state.PL1); err != nil {
+				return corrections, fmt.Errorf("could not re-apply PL1 on cpu %d: %s", cpu, err)
+			}
+
+			pl1, _ := rpl.GetPowerLimit()
+			corrections = append(corrections, Correction{cpu, "MSR_PKG_POWER_LIMIT PL1 (0x610)",
+				powerLimitString(old, enabled), powerLimitString(pl1, true)})
+		}
+	}
+
+	if state.PL2 != 0 {
+		old, enabled := rpl.GetPowerLimit2()
+		if drifted(old, enabled, state.PL2, rpl.GetPowerUnits()) {
+			if err := rpl.SetPowerLimit2(state.PL2); err != nil {
+				return corrections, fmt.Errorf("could not re-apply PL2 on cpu %d: %s", cpu, err)
+			}
+
+			pl2, _ := rpl.GetPowerLimit2()
+			corrections = append(corrections, Correction{cpu, "MSR_PKG_POWER_LIMIT PL2 (0x610)",
+				powerLimitString(old, enabled), powerLimitString(pl2, true)})
+		}
+	}
+
+	return corrections, nil
+}
+
+func (d *Daemon) enforceThrottleTemp(cpu int, state State) ([]Correction, error) {
+	if state.ThrottleTemp == 0 {
+		return nil, nil
+	}
+
+	tt, err := msr.GetTempTarget(cpu)
+	if err != nil {
+		return nil, fmt.Errorf("could not read temperature target on cpu %d: %s", cpu, err)
+	}
+
+	old := tt.GetThrottleTemp()
+	if old == state.ThrottleTemp {
+		return nil, nil
+	}
+
+	if err := tt.SetThrottleTemp(state.ThrottleTemp); err != nil {
+		return nil, fmt.Errorf("could not re-apply throttle temp on cpu %d: %s", cpu, err)
+	}
+
+	return []Correction{{cpu, "TEMPERATURE_TARGET (0x1a2)",
+		fmt.Sprintf("%dC", old), fmt.Sprintf("%dC", tt.GetThrottleTemp())}}, nil
+}
+
+func powerLimitString(watts float64, enabled bool) string {
+	if !enabled {
+		return fmt.Sprintf("%0.2fW (disabled)", watts)
+	}
+	return fmt.Sprintf("%0.2fW", watts)
+}
@@ -0,0 +1,201 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/control"
+	"github.com/davidr/ddtp/pkg/msr"
+	"github.com/davidr/ddtp/pkg/sim"
+)
+
+func TestDrifted(t *testing.T) {
+	units := 0.125
+
+	if drifted(25.125, true, 25.1, units) {
+		t.Errorf("25.125W should match a 25.1W target at 1/8W granularity")
+	}
+
+	if !drifted(15, true, 25, units) {
+		t.Errorf("15W should have drifted from a 25W target")
+	}
+
+	if !drifted(25, false, 25, units) {
+		t.Errorf("a disabled limit should count as drifted")
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	s, err := sim.New(sim.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msr.SetBackend(msr.SetBackend(s))
+
+	d := New([]int{0}, time.Second, State{PL1: 20})
+	if _, err := d.Enforce(); err != nil {
+		t.Fatal(err)
+	}
+
+	// firmware drops PL1 back behind the daemon's back
+	rpl, err := msr.GetRAPLPowerLimit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpl.SetPowerLimit(10); err != nil {
+		t.Fatal(err)
+	}
+
+	corrections, err := d.Enforce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corrections) != 1 || corrections[0].Register != "MSR_PKG_POWER_LIMIT PL1 (0x610)" {
+		t.Errorf("corrections were %v, should be one for PL1", corrections)
+	}
+
+	rpl, err = msr.GetRAPLPowerLimit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl1, enabled := rpl.GetPowerLimit(); pl1 != 20 || !enabled {
+		t.Errorf("PL1 is %gW (enabled %t) after enforcing, should be 20W", pl1, enabled)
+	}
+
+	if corrections, _ := d.Enforce(); len(corrections) != 0 {
+		t.Errorf("corrections were %v with nothing drifted, should be none", corrections)
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	b := debouncer{delay: 3 * time.Second, current: "ac"}
+	start := time.Now()
+
+	if b.update("battery", start) || b.update("battery", start.Add(2*time.Second)) {
+		t.Errorf("switched to battery before the debounce delay")
+	}
+
+	// a blip back to ac restarts the clock
+	b.update("ac", start.Add(2500*time.Millisecond))
+	if b.update("battery", start.Add(4*time.Second)) {
+		t.Errorf("switched to battery even though ac came back")
+	}
+
+	if !b.update("battery", start.Add(7*time.Second)) || b.current != "battery" {
+		t.Errorf("did not switch to battery after the debounce delay")
+	}
+
+	if b.update("battery", start.Add(8*time.Second)) {
+		t.Errorf("switched to battery twice")
+	}
+}
+
+func TestSleepDetector(t *testing.T) {
+	s := sleepDetector{offset: 10 * time.Second}
+
+	if _, resumed := s.update(10*time.Second + time.Millisecond); resumed {
+		t.Errorf("a millisecond of clock skew counted as a resume")
+	}
+
+	if slept, resumed := s.update(70 * time.Second); !resumed || slept < 59*time.Second {
+		t.Errorf("a minute asleep gave slept %s resumed:%t", slept, resumed)
+	}
+}
+
+func TestWantedProfile(t *testing.T) {
+	d := NewProfile(nil, time.Second, &config.Profile{Name: "quiet"})
+	if name := d.wantedProfile(); name != "quiet" {
+		t.Errorf("wanted %s with no trigger, should be quiet", name)
+	}
+
+	d.triggered = "build"
+	if name := d.wantedProfile(); name != "build" {
+		t.Errorf("wanted %s with build triggered, should be build", name)
+	}
+
+	d = &Daemon{auto: true, source: debouncer{current: "battery"}}
+	if name := d.wantedProfile(); name != "battery" {
+		t.Errorf("wanted %s on battery, should be battery", name)
+	}
+
+	d.triggered = "game"
+	if name := d.wantedProfile(); name != "game" {
+		t.Errorf("wanted %s on battery with game triggered, should be game", name)
+	}
+}
+
+func TestSwitchProfileRestores(t *testing.T) {
+	s, err := sim.New(sim.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msr.SetBackend(msr.SetBackend(s))
+
+	d := NewProfile([]int{0}, time.Second, &config.Profile{Name: "quiet", Temp: 90})
+	d.profiles = &config.Config{Profiles: map[string]*config.Profile{"build": {Name: "build", PL1: 25}}}
+
+	d.switchProfile("quiet")
+	d.switchProfile("build")
+	if live, _ := d.readState(); live.PL1 != 25 || live.ThrottleTemp != 100 {
+		t.Errorf("live state is %+v under build, should have its PL1 of 25W and the original 100C", live)
+	}
+
+	// quiet doesn't set PL1, so build's higher one mustn't outlast it
+	d.switchProfile("quiet")
+	if live, _ := d.readState(); live.PL1 != 15 || live.ThrottleTemp != 90 {
+		t.Errorf("live state is %+v back under quiet, should have the original PL1 of 15W and 90C", live)
+	}
+}
+
+func TestTemporaryState(t *testing.T) {
+	s, err := sim.New(sim.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msr.SetBackend(msr.SetBackend(s))
+
+	d := New([]int{0}, time.Second, State{PL1: 12})
+	if _, err := d.Enforce(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetTemporaryState(State{PL1: 20, ThrottleTemp: 80}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if live, _ := d.readState(); live.PL1 != 20 || live.ThrottleTemp != 80 {
+		t.Errorf("live state is %+v with temporary settings, should have PL1 20W and 80C", live)
+	}
+
+	// PL1 goes back to the held state, and the throttle temperature (which the held state
+	// doesn't cover) to what it was before
+	if err := d.ClearTemporaryState(); err != nil {
+		t.Fatal(err)
+	}
+	if live, _ := d.readState(); live.PL1 != 12 || live.ThrottleTemp != 100 {
+		t.Errorf("live state is %+v after clearing, should have PL1 12W and 100C", live)
+	}
+}
+
+func TestApplyProfileWithController(t *testing.T) {
+	s, err := sim.New(sim.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msr.SetBackend(msr.SetBackend(s))
+
+	c, err := control.New(control.Config{CPU: 0, Target: 80, MinPL1: 5, MaxPL1: 30, Kp: 0.5, MaxRate: 2,
+		Interval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewProfile([]int{0}, time.Second, &config.Profile{Name: "quiet", PL1: 25, Temp: 90})
+	d.SetController(c)
+	d.applyProfile()
+
+	// the controller's PL1 (the sim's default of 15W) stays, and the rest of the profile goes on
+	if live, _ := d.readState(); live.PL1 != 15 || live.ThrottleTemp != 90 {
+		t.Errorf("live state is %+v, should have the controller's PL1 of 15W and the profile's 90C", live)
+	}
+}
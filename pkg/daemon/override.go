@@ -0,0 +1,206 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/config"
+	"github.com/davidr/ddtp/pkg/msr"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status is a snapshot of what the daemon is doing. Times are only set for things that
+// expire.
+type Status struct {
+	Profile        string     `json:"profile,omitempty"`   // profile being held
+	Source         string     `json:"source,omitempty"`    // power source, with NewAuto
+	Triggered      string     `json:"triggered,omitempty"` // profile a trigger selected
+	Override       string     `json:"override,omitempty"`  // profile set with OverrideProfile
+	OverrideUntil  *time.Time `json:"override_until,omitempty"`
+	State          State      `json:"state"`     // what's being enforced, temporary settings included
+	Temporary      State      `json:"temporary"` // settings from SetTemporaryState
+	TemporaryUntil *time.Time `json:"temporary_until,omitempty"`
+	Controlling    bool       `json:"controlling"` // a controller owns PL1
+}
+
+// Status returns what the daemon is doing
+func (d *Daemon) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := Status{Triggered: d.triggered, State: d.enforcedState(), Temporary: d.temporary, Controlling: d.controller != nil}
+	if d.active != nil {
+		s.Profile = d.active.Name
+	}
+	if d.auto {
+		s.Source = d.source.current
+	}
+	if d.override != nil {
+		s.Override = d.override.Name
+		s.OverrideUntil = untilPtr(d.overrideUntil)
+	}
+	if !d.temporary.IsEmpty() {
+		s.TemporaryUntil = untilPtr(d.temporaryUntil)
+	}
+
+	return s
+}
+
+func untilPtr(until time.Time) *time.Time {
+	if until.IsZero() {
+		return nil
+	}
+	return &until
+}
+
+// OverrideProfile holds p, over triggers and the power source, until until (or until it's
+// cleared, if until is zero). A nil p clears the override. It only works for daemons from
+// NewProfile or NewAuto, which have a profile to go back to afterwards.
+func (d *Daemon) OverrideProfile(p *config.Profile, until time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.base == nil && !d.auto {
+		return fmt.Errorf("daemon: not holding a profile")
+	}
+
+	if p == nil {
+		log.Infof("daemon: profile override cleared")
+	} else {
+		log.Infof("daemon: overriding profile with %s", p.Name)
+	}
+	d.override, d.overrideUntil = p, until
+
+	if d.active == nil || d.active.Name != d.wantedProfile() {
+		d.switchProfile(d.wantedProfile())
+		d.enforce()
+	}
+	return nil
+}
+
+// SetTemporaryState lays the non-zero settings in s over the held state until until (or
+// until they're cleared, if until is zero), on top of any set before. What they replace is
+// put back when they end.
+func (d *Daemon) SetTemporaryState(s State, until time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if s.IsEmpty() {
+		return fmt.Errorf("daemon: no settings given")
+	}
+	if s.PL1 != 0 && d.controller != nil {
+		return fmt.Errorf("daemon: PL1 belongs to the controller")
+	}
+
+	// Only take what's live for settings that aren't already temporary, or the restore
+	// would just put back the last temporary value
+	live, err := d.readState()
+	if err != nil {
+		return err
+	}
+	if s.PL1 != 0 && d.temporary.PL1 == 0 {
+		d.restore.PL1 = live.PL1
+	}
+	if s.PL2 != 0 && d.temporary.PL2 == 0 {
+		d.restore.PL2 = live.PL2
+	}
+	if s.ThrottleTemp != 0 && d.temporary.ThrottleTemp == 0 {
+		d.restore.ThrottleTemp = live.ThrottleTemp
+	}
+
+	d.temporary = overlay(d.temporary, s)
+	d.temporaryUntil = until
+	log.Infof("daemon: temporary settings %+v", d.temporary)
+
+	_, err = d.Enforce()
+	return err
+}
+
+// ClearTemporaryState ends any temporary settings now
+func (d *Daemon) ClearTemporaryState() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.endTemporary()
+}
+
+// endTemporary drops the temporary settings and puts back what they replaced. Settings the
+// held state covers go back to that; the rest go back to what was live beforehand.
+func (d *Daemon) endTemporary() error {
+	if d.temporary.IsEmpty() {
+		return nil
+	}
+
+	log.Infof("daemon: temporary settings %+v ended", d.temporary)
+	restore := overlay(d.restore, d.state)
+	d.temporary, d.temporaryUntil, d.restore = State{}, time.Time{}, State{}
+
+	_, err := d.enforceState(restore)
+	return err
+}
+
+// expire ends an override or temporary settings whose time is up. It returns true if it
+// did anything.
+func (d *Daemon) expire(now time.Time) bool {
+	expired := false
+
+	if d.override != nil && !d.overrideUntil.IsZero() && now.After(d.overrideUntil) {
+		log.Infof("daemon: profile override %s expired", d.override.Name)
+		d.override = nil
+		expired = true
+	}
+
+	if !d.temporary.IsEmpty() && !d.temporaryUntil.IsZero() && now.After(d.temporaryUntil) {
+		d.logError(d.endTemporary())
+		expired = true
+	}
+
+	return expired
+}
+
+// enforcedState returns the held state with any temporary settings laid over it
+func (d *Daemon) enforcedState() State {
+	return overlay(d.state, d.temporary)
+}
+
+// overlay returns s with the non-zero settings in o replacing its own
+func overlay(s State, o State) State {
+	if o.PL1 != 0 {
+		s.PL1 = o.PL1
+	}
+	if o.PL2 != 0 {
+		s.PL2 = o.PL2
+	}
+	if o.ThrottleTemp != 0 {
+		s.ThrottleTemp = o.ThrottleTemp
+	}
+
+	return s
+}
+
+// readState returns the live settings. They're package scoped, so the first CPU will do.
+func (d *Daemon) readState() (State, error) {
+	var s State
+	if len(d.cpus) == 0 {
+		return s, nil
+	}
+
+	rpl, err := msr.GetRAPLPowerLimit(d.cpus[0])
+	if err != nil {
+		return s, fmt.Errorf("could not read power limits on cpu %d: %s", d.cpus[0], err)
+	}
+	if pl1, enabled := rpl.GetPowerLimit(); enabled {
+		s.PL1 = pl1
+	}
+	if pl2, enabled := rpl.GetPowerLimit2(); enabled {
+		s.PL2 = pl2
+	}
+
+	tt, err := msr.GetTempTarget(d.cpus[0])
+	if err != nil {
+		return s, fmt.Errorf("could not read temperature target on cpu %d: %s", d.cpus[0], err)
+	}
+	s.ThrottleTemp = tt.GetThrottleTemp()
+
+	return s, nil
+}
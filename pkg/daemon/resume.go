@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// resumeThreshold is how far CLOCK_BOOTTIME has to pull ahead of CLOCK_MONOTONIC between
+// polls before we call it a suspend and resume
+const resumeThreshold = time.Second
+
+// sleepDetector notices that the system has been suspended. CLOCK_BOOTTIME keeps counting
+// through suspend and CLOCK_MONOTONIC doesn't (they're otherwise slewed identically), so
+// the gap between them grows by exactly the time spent asleep.
+type sleepDetector struct {
+	offset time.Duration
+}
+
+// suspendedTime returns the total time the system has spent suspended since boot
+func suspendedTime() (time.Duration, error) {
+	var boot, mono unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot); err != nil {
+		return 0, err
+	}
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(boot.Nano() - mono.Nano()), nil
+}
+
+// check returns how long the system slept if it has been suspended since the last check
+func (s *sleepDetector) check() (time.Duration, bool, error) {
+	offset, err := suspendedTime()
+	if err != nil {
+		return 0, false, err
+	}
+
+	slept, resumed := s.update(offset)
+	return slept, resumed, nil
+}
+
+func (s *sleepDetector) update(offset time.Duration) (time.Duration, bool) {
+	slept := offset - s.offset
+	s.offset = offset
+
+	return slept, slept >= resumeThreshold
+}
@@ -0,0 +1,69 @@
+package msr
+
+import (
+	"time"
+)
+
+// Backend is what register reads and writes (and CPUID) go through. The default is the
+// kernel's msr and cpuid drivers under /dev/cpu; pkg/sim provides a simulated package for
+// tests.
+type Backend interface {
+	ReadMSR(cpu int, reg int64) (uint64, error)
+	WriteMSR(cpu int, reg int64, value uint64) error
+	CPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error)
+}
+
+// Clock is implemented by backends that keep their own time, so that anything measuring
+// rates (power, say) against them sees the backend's time rather than the wall clock
+type Clock interface {
+	Now() time.Time
+}
+
+// devBackend talks to the hardware through /dev/cpu/N/{msr,cpuid}
+type devBackend struct{}
+
+func (devBackend) ReadMSR(cpu int, reg int64) (uint64, error) {
+	MSRFile, err := GetMsrFile(cpu)
+	if err != nil {
+		return 0, err
+	}
+
+	return readMSRIntValue(MSRFile, reg)
+}
+
+func (devBackend) WriteMSR(cpu int, reg int64, value uint64) error {
+	MSRFile, err := GetMsrFile(cpu)
+	if err != nil {
+		return err
+	}
+
+	return WriteMSRIntValue(MSRFile, reg, value)
+}
+
+func (devBackend) CPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error) {
+	return readDevCPUID(cpu, leaf, subleaf)
+}
+
+var backend Backend = devBackend{}
+
+// SetBackend routes all register access through b, and returns the backend it replaced. A
+// nil b restores the default.
+func SetBackend(b Backend) Backend {
+	prev := backend
+	if b == nil {
+		b = devBackend{}
+	}
+
+	backend = b
+	return prev
+}
+
+// Now returns the current time according to the backend: the wall clock, unless the
+// backend is a Clock
+func Now() time.Time {
+	if c, ok := backend.(Clock); ok {
+		return c.Now()
+	}
+
+	return time.Now()
+}
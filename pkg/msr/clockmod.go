@@ -0,0 +1,124 @@
+package msr
+
+import (
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const clockModulationEnableBit = 4
+
+// ClockModulation is a struct corresponding to the IA32_CLOCK_MODULATION MSR for a CPU.
+// With on-demand modulation enabled, the core's clock is gated for (100 - duty)% of the
+// time regardless of what RAPL or the OS want. It's a blunt instrument, but it's the one
+// thing left when the power limits are locked.
+type ClockModulation struct {
+	cpu      int
+	enabled  bool
+	duty     float64 // percent of time the clock runs
+	extended bool    // 6.25% steps instead of 12.5%
+}
+
+// GetClockModulation returns a ClockModulation struct for cpu
+func GetClockModulation(cpu int) (ClockModulation, error) {
+	cm := ClockModulation{cpu: cpu}
+
+	// CPUID.06H:EAX[5] tells us if the extended (4 bit) duty cycle is supported. If we
+	// can't ask, assume not; the 3 bit encoding works everywhere.
+	regs, err := readCPUID(cpu, 0x6, 0)
+	if err != nil {
+		log.Infof("could not read cpuid on cpu %d, assuming no extended clock modulation: %s", cpu, err)
+	} else {
+		cm.extended = (regs[0]>>5)&0x1 == 1
+	}
+
+	buf, err := readCPUMSR(cpu, clockModulation)
+	if err != nil {
+		return cm, err
+	}
+
+	cm.enabled, cm.duty = unpackClockModulation(buf, cm.extended)
+	log.Debugf("clock modulation: enabled:%t duty %0.2f%% extended:%t", cm.enabled, cm.duty, cm.extended)
+	return cm, nil
+}
+
+func unpackClockModulation(buf uint64, extended bool) (bool, float64) {
+	if (buf>>clockModulationEnableBit)&0x1 == 0 {
+		return false, 100
+	}
+
+	if extended {
+		return true, float64(buf&0xf) * 6.25 // bits 3:0
+	}
+	return true, float64((buf>>1)&0x7) * 12.5 // bits 3:1
+}
+
+// packClockModulation returns the low 5 bits of IA32_CLOCK_MODULATION for duty, rounded to
+// the nearest step the CPU supports. 100% turns modulation off, and so does anything that
+// rounds up to it: the duty field has no encoding for a full step count.
+func packClockModulation(duty float64, extended bool) (uint64, error) {
+	if duty <= 0 || duty > 100 {
+		return 0, fmt.Errorf("msr: clock modulation duty cycle %0.2f%% out of range (0, 100]", duty)
+	}
+
+	stepSize, steps, shift := 12.5, uint64(8), uint(1)
+	if extended {
+		stepSize, steps, shift = 6.25, 16, 0
+	}
+
+	step := uint64(math.Round(duty / stepSize))
+	if step >= steps {
+		return 0, nil
+	}
+	if step < 1 {
+		step = 1
+	}
+
+	return 1<<clockModulationEnableBit | step<<shift, nil
+}
+
+// IsEnabled returns true if on-demand clock modulation is active
+func (c *ClockModulation) IsEnabled() bool {
+	return c.enabled
+}
+
+// GetDutyCycle returns the percentage of time the clock is allowed to run (100 if
+// modulation is off)
+func (c *ClockModulation) GetDutyCycle() float64 {
+	return c.duty
+}
+
+// IsExtended returns true if the CPU supports 6.25% duty cycle steps
+func (c *ClockModulation) IsExtended() bool {
+	return c.extended
+}
+
+// SetDutyCycle sets the clock modulation duty cycle to duty percent, rounded to the nearest
+// supported step. 100 disables modulation.
+func (c *ClockModulation) SetDutyCycle(duty float64) error {
+	log.Infof("setting clock modulation duty cycle to %0.2f%% on cpu %d", duty, c.cpu)
+	bits, err := packClockModulation(duty, c.extended)
+	if err != nil {
+		return err
+	}
+
+	buf, err := readCPUMSR(c.cpu, clockModulation)
+	if err != nil {
+		return fmt.Errorf("could not read clock modulation for CPU %d: %s", c.cpu, err)
+	}
+
+	newBuf := (buf &^ 0x1f) | bits
+	if newBuf == buf {
+		log.Debugf("clock modulation already at %0.2f%%. NOOP", duty)
+		return nil
+	}
+
+	err = writeCPUMSR(c.cpu, clockModulation, newBuf)
+	if err != nil {
+		return fmt.Errorf("could not write clock modulation for CPU %d: %s", c.cpu, err)
+	}
+
+	c.enabled, c.duty = unpackClockModulation(newBuf, c.extended)
+	return nil
+}
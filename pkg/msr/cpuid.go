@@ -0,0 +1,66 @@
+package msr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/davidr/ddtp/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// readCPUID executes CPUID with leaf (and subleaf) on cpu
+func readCPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error) {
+	return backend.CPUID(cpu, leaf, subleaf)
+}
+
+// readDevCPUID executes CPUID with leaf (and subleaf) on cpu by way of the cpuid driver. The
+// file works just like the msr one: seek to the leaf in the low 32 bits and the subleaf in
+// the high 32 bits, and read back eax, ebx, ecx and edx.
+func readDevCPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error) {
+	var regs [4]uint32
+
+	if !util.IsValidCPU(cpu) {
+		return regs, fmt.Errorf("msr: invalid CPU number %d", cpu)
+	}
+
+	cpuidFile := fmt.Sprintf("/dev/cpu/%d/cpuid", cpu)
+	file, err := os.Open(cpuidFile)
+	if err != nil {
+		return regs, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 16)
+	_, err = file.ReadAt(buf, int64(uint64(subleaf)<<32|uint64(leaf)))
+	if err != nil {
+		return regs, err
+	}
+
+	for i := range regs {
+		regs[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+
+	log.Debugf("cpuid 0x%x.%d on cpu %d: %08x %08x %08x %08x", leaf, subleaf, cpu, regs[0], regs[1], regs[2], regs[3])
+	return regs, nil
+}
+
+// getCPUModel returns the family and (extended) model of cpu from CPUID leaf 1, e.g. 6 and
+// 0x8c for Tiger Lake
+func getCPUModel(cpu int) (int, int, error) {
+	regs, err := readCPUID(cpu, 0x1, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	family := int((regs[0] >> 8) & 0xf)
+	model := int((regs[0] >> 4) & 0xf)
+	if family == 0x6 || family == 0xf {
+		model |= int((regs[0]>>16)&0xf) << 4
+	}
+	if family == 0xf {
+		family += int((regs[0] >> 20) & 0xff)
+	}
+
+	return family, model, nil
+}
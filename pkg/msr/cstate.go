@@ -0,0 +1,165 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CoreCStates lists the core C-states with residency counters
+var CoreCStates = []string{"C3", "C6", "C7"}
+
+// PackageCStates lists the package C-states with residency counters
+var PackageCStates = []string{"PC2", "PC3", "PC6", "PC7", "PC8", "PC9", "PC10"}
+
+var cstateRegisters = map[string]int64{
+	"C3":   coreC3Residency,
+	"C6":   coreC6Residency,
+	"C7":   coreC7Residency,
+	"PC2":  pkgC2Residency,
+	"PC3":  pkgC3Residency,
+	"PC6":  pkgC6Residency,
+	"PC7":  pkgC7Residency,
+	"PC8":  pkgC8Residency,
+	"PC9":  pkgC9Residency,
+	"PC10": pkgC10Residency,
+}
+
+// Encoding of the package C-state limit in MSR_PKG_CST_CONFIG_CONTROL b3:0 on client parts
+var pkgCStateLimits = map[int]string{
+	0: "PC0/PC1",
+	1: "PC2",
+	2: "PC3",
+	3: "PC6",
+	4: "PC7",
+	5: "PC7s",
+	6: "PC8",
+	7: "PC9",
+	8: "PC10",
+}
+
+// CStateCounters is a snapshot of the residency counters for a set of C-states on a CPU
+type CStateCounters struct {
+	CPU      int
+	TSC      uint64
+	Counters map[string]uint64
+}
+
+// CStateSample is the percentage of an interval a CPU (or its package) spent in each C-state
+type CStateSample struct {
+	CPU       int
+	Residency map[string]float64
+}
+
+// ReadCStateCounters reads the TSC and the residency counters for states on cpu
+func ReadCStateCounters(cpu int, states []string) (CStateCounters, error) {
+	csc := CStateCounters{CPU: cpu, Counters: map[string]uint64{}}
+
+	var err error
+	if csc.TSC, err = readCPUMSR(cpu, timeStampCounter); err != nil {
+		return csc, err
+	}
+
+	for _, state := range states {
+		reg, ok := cstateRegisters[state]
+		if !ok {
+			return csc, fmt.Errorf("msr: unknown C-state '%s'", state)
+		}
+
+		if csc.Counters[state], err = readCPUMSR(cpu, reg); err != nil {
+			return csc, err
+		}
+	}
+
+	return csc, nil
+}
+
+// CalcCStateSample computes the residency percentages between two snapshots of the same
+// CPU's counters
+func CalcCStateSample(prev CStateCounters, cur CStateCounters) CStateSample {
+	sample := CStateSample{CPU: cur.CPU, Residency: map[string]float64{}}
+
+	tsc := float64(cur.TSC - prev.TSC)
+	for state, count := range cur.Counters {
+		if tsc > 0 {
+			sample.Residency[state] = 100 * float64(count-prev.Counters[state]) / tsc
+		}
+	}
+
+	return sample
+}
+
+// CStateSampler keeps the previous counter snapshot for a set of CPUs so that each call to
+// Sample reports on the interval since the last one
+type CStateSampler struct {
+	cpus   []int
+	states []string
+	prev   map[int]CStateCounters
+}
+
+// NewCStateSampler returns a CStateSampler for states on cpus. Not every part has every
+// counter (PC8-PC10 are mobile only, for instance), so any state whose counter can't be
+// read on the first CPU is dropped; check GetStates for what's left.
+func NewCStateSampler(cpus []int, states []string) (*CStateSampler, error) {
+	cs := &CStateSampler{cpus: cpus, prev: map[int]CStateCounters{}}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("msr: no CPUs to sample")
+	}
+
+	for _, state := range states {
+		if _, err := ReadCStateCounters(cpus[0], []string{state}); err != nil {
+			log.Infof("skipping %s residency: %s", state, err)
+			continue
+		}
+		cs.states = append(cs.states, state)
+	}
+
+	for _, cpu := range cpus {
+		csc, err := ReadCStateCounters(cpu, cs.states)
+		if err != nil {
+			return nil, fmt.Errorf("msr: could not read C-state counters on cpu %d: %s", cpu, err)
+		}
+		cs.prev[cpu] = csc
+	}
+
+	return cs, nil
+}
+
+// GetStates returns the C-states this sampler reports on
+func (cs *CStateSampler) GetStates() []string {
+	return cs.states
+}
+
+// Sample returns a CStateSample for each CPU covering the time since the last call (or
+// since NewCStateSampler)
+func (cs *CStateSampler) Sample() ([]CStateSample, error) {
+	var samples []CStateSample
+
+	for _, cpu := range cs.cpus {
+		csc, err := ReadCStateCounters(cpu, cs.states)
+		if err != nil {
+			return samples, fmt.Errorf("msr: could not read C-state counters on cpu %d: %s", cpu, err)
+		}
+
+		samples = append(samples, CalcCStateSample(cs.prev[cpu], csc))
+		cs.prev[cpu] = csc
+	}
+
+	return samples, nil
+}
+
+// GetPackageCStateLimit returns the deepest package C-state the CPU is allowed to enter and
+// whether the firmware has locked that limit
+func GetPackageCStateLimit(cpu int) (string, bool, error) {
+	buf, err := readCPUMSR(cpu, pkgCStConfig)
+	if err != nil {
+		return "", false, err
+	}
+
+	limit, ok := pkgCStateLimits[int(buf&0xf)] // bits 3:0
+	if !ok {
+		limit = fmt.Sprintf("unknown (%d)", buf&0xf)
+	}
+
+	return limit, (buf>>15)&0x1 == 1, nil // bit 15
+}
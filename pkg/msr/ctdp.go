@@ -0,0 +1,182 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ConfigTDPLevels lists the names used on the command line for Config TDP levels. "up" and
+// "down" aren't fixed register levels; they're resolved against the nominal level by
+// ConfigTDP.GetLevelByName
+var ConfigTDPLevels = []string{"nominal", "up", "down"}
+
+// ConfigTDPLevel is one of the (up to three) Configurable TDP levels supported by a CPU
+type ConfigTDPLevel struct {
+	Level    int     // 0 is nominal, 1 and 2 are the additional levels
+	Ratio    int     // max non-turbo ratio at this level
+	TDP      float64 // package TDP in W
+	MaxPower float64 // max package power in W (0 if not reported)
+	MinPower float64 // min package power in W (0 if not reported)
+}
+
+// ConfigTDP is a struct corresponding to the MSR_CONFIG_TDP_* and MSR_TURBO_ACTIVATION_RATIO
+// MSRs for a CPU. This is how the firmware implements "performance mode" on most ThinkPads.
+type ConfigTDP struct {
+	cpu                   int
+	levels                []ConfigTDPLevel
+	active                int  // currently selected level
+	locked                bool // MSR_CONFIG_TDP_CONTROL lock bit
+	turboActivationRatio  int
+	turboActivationLocked bool
+}
+
+// GetConfigTDP returns a ConfigTDP struct for cpu
+func GetConfigTDP(cpu int) (ConfigTDP, error) {
+	ctdp := ConfigTDP{cpu: cpu}
+
+	// The levels are given in the same power units as the RAPL registers
+	rplUnitBitfield, err := readCPUMSR(cpu, powerLimitUnits)
+	if err != nil {
+		return ctdp, err
+	}
+	powerUnits, _ := getRAPLPowerUnits(rplUnitBitfield)
+
+	pi, err := GetPlatformInfo(cpu)
+	if err != nil {
+		return ctdp, err
+	}
+	extraLevels := pi.GetConfigTDPLevels()
+	log.Debugf("cpu %d supports %d config TDP levels beyond nominal", cpu, extraLevels)
+
+	// The nominal level only has a ratio; its TDP is the package thermal spec power
+	nominalBitfield, err := readCPUMSR(cpu, configTDPNominal)
+	if err != nil {
+		return ctdp, err
+	}
+	powerInfoBitfield, err := readCPUMSR(cpu, pkgPowerInfo)
+	if err != nil {
+		return ctdp, err
+	}
+	ctdp.levels = append(ctdp.levels, ConfigTDPLevel{
+		Level: 0,
+		Ratio: int(nominalBitfield & 0xff),
+		TDP:   float64(powerInfoBitfield&0x7fff) * powerUnits,
+	})
+
+	for i, reg := range []int64{configTDPLevel1, configTDPLevel2}[:extraLevels] {
+		levelBitfield, err := readCPUMSR(cpu, reg)
+		if err != nil {
+			return ctdp, err
+		}
+
+		ctdp.levels = append(ctdp.levels, unpackConfigTDPLevel(i+1, levelBitfield, powerUnits))
+	}
+
+	controlBitfield, err := readCPUMSR(cpu, configTDPControl)
+	if err != nil {
+		return ctdp, err
+	}
+	ctdp.active = int(controlBitfield & 0x3)     // bits 1:0
+	ctdp.locked = (controlBitfield>>31)&0x1 == 1 // bit 31
+
+	tarBitfield, err := readCPUMSR(cpu, turboActivationRatio)
+	if err != nil {
+		return ctdp, err
+	}
+	ctdp.turboActivationRatio = int(tarBitfield & 0xff)     // bits 7:0
+	ctdp.turboActivationLocked = (tarBitfield>>31)&0x1 == 1 // bit 31
+
+	log.Debugf("config tdp: active level %d locked:%t, turbo activation ratio %d locked:%t",
+		ctdp.active, ctdp.locked, ctdp.turboActivationRatio, ctdp.turboActivationLocked)
+	return ctdp, nil
+}
+
+// unpackConfigTDPLevel decodes MSR_CONFIG_TDP_LEVEL1/2
+func unpackConfigTDPLevel(level int, levelBitfield uint64, powerUnits float64) ConfigTDPLevel {
+	return ConfigTDPLevel{
+		Level:    level,
+		TDP:      float64(levelBitfield&0x7fff) * powerUnits,       // bits 14:0
+		Ratio:    int((levelBitfield >> 16) & 0xff),                // bits 23:16
+		MaxPower: float64((levelBitfield>>32)&0x7fff) * powerUnits, // bits 46:32
+		MinPower: float64((levelBitfield>>48)&0x7fff) * powerUnits, // bits 62:48
+	}
+}
+
+// GetLevels returns all of the Config TDP levels supported by the CPU, nominal first
+func (c *ConfigTDP) GetLevels() []ConfigTDPLevel {
+	return c.levels
+}
+
+// GetActiveLevel returns the currently selected Config TDP level
+func (c *ConfigTDP) GetActiveLevel() int {
+	return c.active
+}
+
+// IsLocked returns true if the firmware has locked the Config TDP level selection
+func (c *ConfigTDP) IsLocked() bool {
+	return c.locked
+}
+
+// GetTurboActivationRatio returns the ratio above which requests are treated as turbo and
+// whether that value is locked
+func (c *ConfigTDP) GetTurboActivationRatio() (int, bool) {
+	return c.turboActivationRatio, c.turboActivationLocked
+}
+
+// GetLevelName returns the name ("nominal", "up" or "down") of a level relative to nominal
+func (c *ConfigTDP) GetLevelName(level int) string {
+	if level == 0 || level >= len(c.levels) {
+		return "nominal"
+	}
+
+	if c.levels[level].TDP > c.levels[0].TDP {
+		return "up"
+	}
+	return "down"
+}
+
+// GetLevelByName returns the level number for one of the names in ConfigTDPLevels. Which
+// of levels 1 and 2 is "up" and which is "down" varies by CPU, so we compare each against
+// the nominal TDP.
+func (c *ConfigTDP) GetLevelByName(name string) (int, error) {
+	for _, l := range c.levels {
+		if c.GetLevelName(l.Level) == name {
+			return l.Level, nil
+		}
+	}
+
+	return 0, fmt.Errorf("msr: cpu %d has no config TDP level '%s'", c.cpu, name)
+}
+
+// SetLevel selects a new Config TDP level
+func (c *ConfigTDP) SetLevel(level int) error {
+	log.Infof("setting config tdp level to %d on cpu %d", level, c.cpu)
+	if level < 0 || level >= len(c.levels) {
+		return fmt.Errorf("msr: cpu %d does not support config TDP level %d", c.cpu, level)
+	}
+
+	if level == c.active {
+		log.Debugf("config tdp level already set to %d. NOOP", level)
+		return nil
+	}
+
+	if c.locked {
+		return fmt.Errorf("msr: config TDP control is locked on cpu %d", c.cpu)
+	}
+
+	// Read the register again rather than trusting our copy so that we only touch bits 1:0
+	controlBitfield, err := readCPUMSR(c.cpu, configTDPControl)
+	if err != nil {
+		return fmt.Errorf("could not read config TDP control for CPU %d: %s", c.cpu, err)
+	}
+
+	controlBitfield = (controlBitfield &^ 0x3) | uint64(level)
+	err = writeCPUMSR(c.cpu, configTDPControl, controlBitfield)
+	if err != nil {
+		return fmt.Errorf("could not set config TDP level for CPU %d: %s", c.cpu, err)
+	}
+
+	c.active = level
+	return nil
+}
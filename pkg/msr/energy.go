@@ -0,0 +1,77 @@
+package msr
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ReadPackageEnergy returns the raw package energy counter for the package cpu is in. The
+// counter is in energy status units (see GetEnergyUnits) and wraps at 32 bits.
+func ReadPackageEnergy(cpu int) (uint64, error) {
+	buf, err := readCPUMSR(cpu, pkgEnergyStatus)
+	if err != nil {
+		return 0, err
+	}
+
+	return buf & 0xffffffff, nil // bits 31:0
+}
+
+// GetEnergyUnits returns the size of an energy status unit in J
+func GetEnergyUnits(cpu int) (float64, error) {
+	buf, err := readCPUMSR(cpu, powerLimitUnits)
+	if err != nil {
+		return 0, err
+	}
+
+	return 1 / math.Pow(2, float64((buf>>8)&0x1f)), nil // bits 12:8
+}
+
+// CalcPackagePower returns the average power in W between two energy counter readings
+// taken elapsed apart, allowing for the counter wrapping
+func CalcPackagePower(prev uint64, cur uint64, units float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64((cur-prev)&0xffffffff) * units / elapsed.Seconds()
+}
+
+// PackagePowerSampler keeps the previous energy reading for a package so that each call to
+// Sample reports the average power since the last one
+type PackagePowerSampler struct {
+	cpu      int
+	units    float64
+	prev     uint64
+	prevTime time.Time
+}
+
+// NewPackagePowerSampler returns a PackagePowerSampler for cpu's package, primed with an
+// initial reading
+func NewPackagePowerSampler(cpu int) (*PackagePowerSampler, error) {
+	units, err := GetEnergyUnits(cpu)
+	if err != nil {
+		return nil, fmt.Errorf("msr: could not read energy units on cpu %d: %s", cpu, err)
+	}
+
+	energy, err := ReadPackageEnergy(cpu)
+	if err != nil {
+		return nil, fmt.Errorf("msr: could not read package energy on cpu %d: %s", cpu, err)
+	}
+
+	return &PackagePowerSampler{cpu: cpu, units: units, prev: energy, prevTime: Now()}, nil
+}
+
+// Sample returns the average package power in W since the last call (or since
+// NewPackagePowerSampler)
+func (ps *PackagePowerSampler) Sample() (float64, error) {
+	now := Now()
+	energy, err := ReadPackageEnergy(ps.cpu)
+	if err != nil {
+		return 0, fmt.Errorf("msr: could not read package energy on cpu %d: %s", ps.cpu, err)
+	}
+
+	watts := CalcPackagePower(ps.prev, energy, ps.units, now.Sub(ps.prevTime))
+	ps.prev, ps.prevTime = energy, now
+	return watts, nil
+}
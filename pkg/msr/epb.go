@@ -0,0 +1,81 @@
+package msr
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EPBValues maps the names the kernel uses for the Energy Performance Bias hint to their
+// values in IA32_ENERGY_PERF_BIAS
+var EPBValues = map[string]int{
+	"performance":         0,
+	"balance-performance": 4,
+	"normal":              6,
+	"balance-power":       8,
+	"power":               15,
+}
+
+// GetEnergyPerfBias returns the Energy Performance Bias hint (0 is performance, 15 is
+// power saving) for cpu
+func GetEnergyPerfBias(cpu int) (int, error) {
+	buf, err := readCPUMSR(cpu, energyPerfBias)
+	if err != nil {
+		return 0, fmt.Errorf("msr: could not read energy perf bias on cpu %d: %s", cpu, err)
+	}
+
+	return int(buf & 0xf), nil // bits 3:0
+}
+
+// SetEnergyPerfBias sets the Energy Performance Bias hint for cpu to epb. This MSR is per
+// thread, and the firmware and kernel will reset it on some events (e.g. resume).
+func SetEnergyPerfBias(cpu int, epb int) error {
+	log.Infof("setting energy perf bias to %d on cpu %d", epb, cpu)
+	if epb < 0 || epb > 0xf {
+		return fmt.Errorf("msr: energy perf bias %d out of range [0, 15]", epb)
+	}
+
+	buf, err := readCPUMSR(cpu, energyPerfBias)
+	if err != nil {
+		return fmt.Errorf("msr: could not read energy perf bias on cpu %d: %s", cpu, err)
+	}
+
+	if int(buf&0xf) == epb {
+		log.Debugf("energy perf bias already set to %d. NOOP", epb)
+		return nil
+	}
+
+	err = writeCPUMSR(cpu, energyPerfBias, (buf&^0xf)|uint64(epb))
+	if err != nil {
+		return fmt.Errorf("msr: could not set energy perf bias on cpu %d: %s", cpu, err)
+	}
+
+	return nil
+}
+
+// ParseEPB converts either one of the names in EPBValues or a number in [0, 15] into an
+// Energy Performance Bias value
+func ParseEPB(epb string) (int, error) {
+	if value, ok := EPBValues[epb]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(epb)
+	if err != nil || value < 0 || value > 0xf {
+		return 0, fmt.Errorf("msr: invalid energy perf bias '%s'", epb)
+	}
+
+	return value, nil
+}
+
+// EPBName returns the name for an Energy Performance Bias value, or "" if it doesn't have one
+func EPBName(epb int) string {
+	for name, value := range EPBValues {
+		if value == epb {
+			return name
+		}
+	}
+
+	return ""
+}
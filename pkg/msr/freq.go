@@ -0,0 +1,116 @@
+package msr
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FrequencyCounters is a snapshot of the free-running counters for one CPU that we need to
+// work out how fast (and how often) it's actually been running. On their own these are
+// meaningless; take two and hand them to CalcFrequencySample.
+type FrequencyCounters struct {
+	CPU   int
+	TSC   uint64
+	APERF uint64
+	MPERF uint64
+	Time  time.Time
+}
+
+// FrequencySample is the effective frequency and utilization of a CPU between two
+// FrequencyCounters snapshots. The names and math follow turbostat.
+type FrequencySample struct {
+	CPU     int
+	AvgMHz  float64 // average frequency over the whole interval, idle included
+	Busy    float64 // percent of the interval spent in C0
+	BusyMHz float64 // average frequency while in C0
+	TSCMHz  float64
+}
+
+// ReadFrequencyCounters reads the TSC, APERF and MPERF counters for cpu
+func ReadFrequencyCounters(cpu int) (FrequencyCounters, error) {
+	fc := FrequencyCounters{CPU: cpu}
+
+	var err error
+	if fc.TSC, err = readCPUMSR(cpu, timeStampCounter); err != nil {
+		return fc, err
+	}
+	if fc.APERF, err = readCPUMSR(cpu, aperf); err != nil {
+		return fc, err
+	}
+	if fc.MPERF, err = readCPUMSR(cpu, mperf); err != nil {
+		return fc, err
+	}
+
+	fc.Time = time.Now()
+	return fc, nil
+}
+
+// CalcFrequencySample computes a FrequencySample from two snapshots of the same CPU's
+// counters. The counters are free running, so unsigned subtraction gives us the right
+// answer across a wrap.
+func CalcFrequencySample(prev FrequencyCounters, cur FrequencyCounters) FrequencySample {
+	sample := FrequencySample{CPU: cur.CPU}
+
+	seconds := cur.Time.Sub(prev.Time).Seconds()
+	tsc := float64(cur.TSC - prev.TSC)
+	aperfDelta := float64(cur.APERF - prev.APERF)
+	mperfDelta := float64(cur.MPERF - prev.MPERF)
+
+	if seconds <= 0 || tsc == 0 {
+		return sample
+	}
+
+	sample.TSCMHz = tsc / seconds / 1e6
+	sample.AvgMHz = aperfDelta / seconds / 1e6
+	sample.Busy = 100 * mperfDelta / tsc
+	if mperfDelta > 0 {
+		sample.BusyMHz = sample.TSCMHz * aperfDelta / mperfDelta
+	}
+
+	return sample
+}
+
+// FrequencySampler keeps the previous counter snapshot for a set of CPUs so that each call
+// to Sample reports on the interval since the last one
+type FrequencySampler struct {
+	cpus []int
+	prev map[int]FrequencyCounters
+}
+
+// NewFrequencySampler returns a FrequencySampler for cpus, primed with an initial snapshot
+func NewFrequencySampler(cpus []int) (*FrequencySampler, error) {
+	fs := &FrequencySampler{cpus: cpus, prev: map[int]FrequencyCounters{}}
+
+	for _, cpu := range cpus {
+		fc, err := ReadFrequencyCounters(cpu)
+		if err != nil {
+			return nil, fmt.Errorf("msr: could not read frequency counters on cpu %d: %s", cpu, err)
+		}
+		fs.prev[cpu] = fc
+	}
+
+	return fs, nil
+}
+
+// Sample returns a FrequencySample for each CPU covering the time since the last call (or
+// since NewFrequencySampler)
+func (fs *FrequencySampler) Sample() ([]FrequencySample, error) {
+	var samples []FrequencySample
+
+	for _, cpu := range fs.cpus {
+		fc, err := ReadFrequencyCounters(cpu)
+		if err != nil {
+			return samples, fmt.Errorf("msr: could not read frequency counters on cpu %d: %s", cpu, err)
+		}
+
+		sample := CalcFrequencySample(fs.prev[cpu], fc)
+		log.Debugf("cpu %d: avg %0.0fMHz busy %0.2f%% bzy %0.0fMHz", cpu, sample.AvgMHz, sample.Busy, sample.BusyMHz)
+
+		samples = append(samples, sample)
+		fs.prev[cpu] = fc
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,256 @@
+package msr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EPPValues maps the names the kernel uses for Energy Performance Preference to the value
+// it writes to IA32_HWP_REQUEST
+var EPPValues = map[string]int{
+	"performance":         0,
+	"balance_performance": 128,
+	"balance_power":       192,
+	"power":               255,
+}
+
+// HWPCapabilities corresponds to the IA32_HWP_CAPABILITIES MSR for a CPU. All of the values
+// are performance levels, which on client parts line up with ratios.
+type HWPCapabilities struct {
+	Highest       int
+	Guaranteed    int
+	MostEfficient int
+	Lowest        int
+}
+
+// HWPRequest is a struct corresponding to the IA32_HWP_REQUEST MSR for a CPU (hardware
+// thread, really; unlike most of what we touch, this one is not package-scoped)
+type HWPRequest struct {
+	cpu            int
+	min            int // minimum performance level
+	max            int // maximum performance level
+	desired        int // 0 lets the hardware pick
+	epp            int // energy performance preference, 0 (performance) to 255 (power)
+	window         time.Duration
+	packageControl bool // request is taken from IA32_HWP_REQUEST_PKG instead
+}
+
+// IsHWPEnabled returns true if hardware P-states (Speed Shift) have been enabled on cpu
+func IsHWPEnabled(cpu int) (bool, error) {
+	buf, err := readCPUMSR(cpu, pmEnable)
+	if err != nil {
+		return false, err
+	}
+
+	return buf&0x1 == 1, nil
+}
+
+// GetHWPCapabilities returns a HWPCapabilities struct for cpu
+func GetHWPCapabilities(cpu int) (HWPCapabilities, error) {
+	buf, err := readCPUMSR(cpu, hwpCapabilities)
+	if err != nil {
+		return HWPCapabilities{}, err
+	}
+
+	return HWPCapabilities{
+		Highest:       int(buf & 0xff),         // bits 7:0
+		Guaranteed:    int((buf >> 8) & 0xff),  // bits 15:8
+		MostEfficient: int((buf >> 16) & 0xff), // bits 23:16
+		Lowest:        int((buf >> 24) & 0xff), // bits 31:24
+	}, nil
+}
+
+// GetHWPRequest returns a HWPRequest struct for cpu
+func GetHWPRequest(cpu int) (HWPRequest, error) {
+	buf, err := readCPUMSR(cpu, hwpRequest)
+	if err != nil {
+		return HWPRequest{cpu: cpu}, err
+	}
+
+	req := unpackHWPRequest(buf)
+	req.cpu = cpu
+	log.Debugf("hwp request: min %d max %d desired %d epp %d window %s", req.min, req.max, req.desired, req.epp, req.window)
+	return req, nil
+}
+
+func unpackHWPRequest(buf uint64) HWPRequest {
+	return HWPRequest{
+		min:            int(buf & 0xff),         // bits 7:0
+		max:            int((buf >> 8) & 0xff),  // bits 15:8
+		desired:        int((buf >> 16) & 0xff), // bits 23:16
+		epp:            int((buf >> 24) & 0xff), // bits 31:24
+		window:         unpackHWPWindow(int((buf >> 32) & 0x3ff)),
+		packageControl: (buf>>42)&0x1 == 1,
+	}
+}
+
+// unpackHWPWindow decodes the 10 bit activity window field. Bits 6:0 are a mantissa and
+// bits 9:7 a base 10 exponent, in microseconds. 0 means the hardware picks the window.
+func unpackHWPWindow(window int) time.Duration {
+	mantissa := float64(window & 0x7f)
+	exponent := float64((window >> 7) & 0x7)
+
+	return time.Duration(mantissa*math.Pow(10, exponent)) * time.Microsecond
+}
+
+// packHWPWindow encodes window into the 10 bit activity window field, using the smallest
+// exponent that fits the mantissa in 7 bits. The field can't represent every duration, so
+// the mantissa is rounded; the longest window is 127 * 10^7us (about 21 minutes).
+func packHWPWindow(window time.Duration) (int, error) {
+	us := window.Microseconds()
+	if us < 0 || us > 127*10000000 {
+		return 0, fmt.Errorf("msr: HWP activity window %s out of range [0, 21m10s]", window)
+	}
+
+	exponent := 0
+	for ; us > 127; exponent++ {
+		us = (us + 5) / 10
+	}
+
+	return exponent<<7 | int(us), nil
+}
+
+// GetMinPerf returns the minimum requested performance level
+func (r *HWPRequest) GetMinPerf() int {
+	return r.min
+}
+
+// GetMaxPerf returns the maximum requested performance level
+func (r *HWPRequest) GetMaxPerf() int {
+	return r.max
+}
+
+// GetDesiredPerf returns the desired performance level (0 means autonomous)
+func (r *HWPRequest) GetDesiredPerf() int {
+	return r.desired
+}
+
+// GetEPP returns the Energy Performance Preference
+func (r *HWPRequest) GetEPP() int {
+	return r.epp
+}
+
+// GetWindow returns the activity window (0 means autonomous)
+func (r *HWPRequest) GetWindow() time.Duration {
+	return r.window
+}
+
+// IsPackageControlled returns true if the CPU is taking its request from the package-level
+// request MSR instead of this one
+func (r *HWPRequest) IsPackageControlled() bool {
+	return r.packageControl
+}
+
+// SetMinPerf sets the minimum requested performance level
+func (r *HWPRequest) SetMinPerf(perf int) error {
+	log.Infof("setting hwp min perf to %d on cpu %d", perf, r.cpu)
+	if err := r.setField(0, 8, perf); err != nil {
+		return err
+	}
+
+	r.min = perf
+	return nil
+}
+
+// SetMaxPerf sets the maximum requested performance level
+func (r *HWPRequest) SetMaxPerf(perf int) error {
+	log.Infof("setting hwp max perf to %d on cpu %d", perf, r.cpu)
+	if err := r.setField(8, 8, perf); err != nil {
+		return err
+	}
+
+	r.max = perf
+	return nil
+}
+
+// SetDesiredPerf sets the desired performance level. 0 lets the hardware decide.
+func (r *HWPRequest) SetDesiredPerf(perf int) error {
+	log.Infof("setting hwp desired perf to %d on cpu %d", perf, r.cpu)
+	if err := r.setField(16, 8, perf); err != nil {
+		return err
+	}
+
+	r.desired = perf
+	return nil
+}
+
+// SetEPP sets the Energy Performance Preference. Note that if the intel_pstate driver is
+// active, it will overwrite this whenever the cpufreq policy changes; going through the
+// energy_performance_preference sysfs file is preferred in that case.
+func (r *HWPRequest) SetEPP(epp int) error {
+	log.Infof("setting hwp epp to %d on cpu %d", epp, r.cpu)
+	if err := r.setField(24, 8, epp); err != nil {
+		return err
+	}
+
+	r.epp = epp
+	return nil
+}
+
+// SetActivityWindow sets the window the hardware averages activity over when picking a
+// performance level. 0 lets the hardware decide.
+func (r *HWPRequest) SetActivityWindow(window time.Duration) error {
+	field, err := packHWPWindow(window)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("setting hwp activity window to %s on cpu %d", window, r.cpu)
+	if err := r.setField(32, 10, field); err != nil {
+		return err
+	}
+
+	r.window = unpackHWPWindow(field)
+	return nil
+}
+
+// setField does a read-modify-write of a width bit field in IA32_HWP_REQUEST. Writes to
+// the request are only defined once HWP has been enabled in IA32_PM_ENABLE; enabling it is
+// one way (until reset) and hands P-state selection to the hardware, so that's left to the
+// kernel (intel_pstate) rather than done behind its back here.
+func (r *HWPRequest) setField(shift uint, width uint, value int) error {
+	mask := uint64(1)<<width - 1
+	if value < 0 || uint64(value) > mask {
+		return fmt.Errorf("msr: HWP request value %d out of range [0, %d]", value, mask)
+	}
+
+	enabled, err := IsHWPEnabled(r.cpu)
+	if err != nil {
+		return fmt.Errorf("could not read HWP enable for CPU %d: %s", r.cpu, err)
+	}
+	if !enabled {
+		return fmt.Errorf("msr: HWP is not enabled in IA32_PM_ENABLE on CPU %d; boot with intel_pstate active to enable it", r.cpu)
+	}
+
+	buf, err := readCPUMSR(r.cpu, hwpRequest)
+	if err != nil {
+		return fmt.Errorf("could not read HWP request for CPU %d: %s", r.cpu, err)
+	}
+
+	buf = (buf &^ (mask << shift)) | uint64(value)<<shift
+	err = writeCPUMSR(r.cpu, hwpRequest, buf)
+	if err != nil {
+		return fmt.Errorf("could not write HWP request for CPU %d: %s", r.cpu, err)
+	}
+
+	return nil
+}
+
+// ParseEPP converts either one of the names in EPPValues or a number in [0, 255] into an
+// Energy Performance Preference value
+func ParseEPP(epp string) (int, error) {
+	if value, ok := EPPValues[epp]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(epp)
+	if err != nil || value < 0 || value > 0xff {
+		return 0, fmt.Errorf("msr: invalid energy performance preference '%s'", epp)
+	}
+
+	return value, nil
+}
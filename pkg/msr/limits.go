@@ -0,0 +1,192 @@
+package msr
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PerfLimitDomains lists the domains that have a *_PERF_LIMIT_REASONS MSR
+var PerfLimitDomains = []string{"core", "graphics", "ring"}
+
+type perfLimitReason struct {
+	bit  uint
+	name string
+}
+
+var perfLimitRegisters = map[string]int64{
+	"core":     corePerfLimitReasons,
+	"graphics": graphicsPerfLimitReasons,
+	"ring":     ringPerfLimitReasons,
+}
+
+// Each status bit in 15:0 has a matching sticky log bit 16 positions higher. The graphics
+// and ring registers use a subset of the core register's layout.
+var perfLimitReasonBits = map[string][]perfLimitReason{
+	"core": {
+		{0, "PROCHOT"},
+		{1, "thermal"},
+		{4, "residency state regulation"},
+		{5, "RATL"},
+		{6, "VR thermal alert"},
+		{7, "VR current (TDC)"},
+		{8, "EDP"},
+		{10, "PL1"},
+		{11, "PL2"},
+		{12, "max turbo"},
+		{13, "turbo attenuation"},
+	},
+	"graphics": {
+		{0, "PROCHOT"},
+		{1, "thermal"},
+		{5, "RATL"},
+		{6, "VR thermal alert"},
+		{7, "VR current (TDC)"},
+		{8, "EDP"},
+		{10, "PL1"},
+		{11, "PL2"},
+		{12, "inefficient operation"},
+	},
+	"ring": {
+		{0, "PROCHOT"},
+		{1, "thermal"},
+		{5, "RATL"},
+		{6, "VR thermal alert"},
+		{7, "VR current (TDC)"},
+		{8, "EDP"},
+		{10, "PL1"},
+		{11, "PL2"},
+	},
+}
+
+// PerfLimitReasons is a struct corresponding to one of the *_PERF_LIMIT_REASONS MSRs for a
+// CPU. These tell us which limit is currently (status) or has at some point since the log was
+// last cleared (logged) kept the domain's frequency below what was requested.
+type PerfLimitReasons struct {
+	cpu    int
+	domain string
+	active []string
+	logged []string
+}
+
+// GetPerfLimitReasons returns a PerfLimitReasons struct for domain (one of
+// PerfLimitDomains) on cpu
+func GetPerfLimitReasons(cpu int, domain string) (PerfLimitReasons, error) {
+	plr := PerfLimitReasons{cpu: cpu, domain: domain}
+
+	reg, ok := perfLimitRegisters[domain]
+	if !ok {
+		return plr, fmt.Errorf("msr: invalid perf limit domain '%s'", domain)
+	}
+
+	plrBitfield, err := readCPUMSR(cpu, reg)
+	if err != nil {
+		return plr, err
+	}
+
+	plr.active, plr.logged = unpackPerfLimitReasons(domain, plrBitfield)
+	log.Debugf("%s perf limit reasons: active %v, logged %v", domain, plr.active, plr.logged)
+	return plr, nil
+}
+
+// unpackPerfLimitReasons returns the names of the active and logged limit reasons in
+// plrBitfield, in bit order
+func unpackPerfLimitReasons(domain string, plrBitfield uint64) ([]string, []string) {
+	var active, logged []string
+
+	for _, reason := range perfLimitReasonBits[domain] {
+		if (plrBitfield>>reason.bit)&0x1 == 1 {
+			active = append(active, reason.name)
+		}
+		if (plrBitfield>>(reason.bit+16))&0x1 == 1 {
+			logged = append(logged, reason.name)
+		}
+	}
+
+	return active, logged
+}
+
+// GetActive returns the names of the limits currently reducing frequency
+func (p *PerfLimitReasons) GetActive() []string {
+	return p.active
+}
+
+// GetLogged returns the names of the limits that have reduced frequency since the log bits
+// were last cleared
+func (p *PerfLimitReasons) GetLogged() []string {
+	return p.logged
+}
+
+// ClearLog clears the sticky log bits. The log bits are R/WC0 and the status bits are read
+// only, so writing all zeros clears every log bit and leaves everything else alone.
+func (p *PerfLimitReasons) ClearLog() error {
+	log.Infof("clearing %s perf limit reasons log on cpu %d", p.domain, p.cpu)
+	err := writeCPUMSR(p.cpu, perfLimitRegisters[p.domain], 0)
+	if err != nil {
+		return fmt.Errorf("could not clear %s perf limit log for CPU %d: %s", p.domain, p.cpu, err)
+	}
+
+	p.logged = nil
+	return nil
+}
+
+// PerfLimitSample holds the percentage of samples in which each limit reason was active
+// for a domain. Reasons that were never active are present with 0.
+type PerfLimitSample struct {
+	Domain   string
+	Samples  int
+	Percents map[string]float64
+}
+
+// GetReasons returns the reason names for the domain in bit order, suitable for ranging
+// over Percents in a stable order
+func (s *PerfLimitSample) GetReasons() []string {
+	var reasons []string
+	for _, reason := range perfLimitReasonBits[s.Domain] {
+		reasons = append(reasons, reason.name)
+	}
+
+	return reasons
+}
+
+// SamplePerfLimitReasons reads the status bits of the perf limit reasons MSR for each of
+// domains on cpu every interval for duration and reports how often each reason was active
+func SamplePerfLimitReasons(cpu int, domains []string, duration time.Duration, interval time.Duration) ([]PerfLimitSample, error) {
+	var samples []PerfLimitSample
+	counts := map[string]map[string]int{}
+
+	if interval <= 0 {
+		return samples, fmt.Errorf("msr: sampling interval must be positive")
+	}
+
+	for _, domain := range domains {
+		samples = append(samples, PerfLimitSample{Domain: domain, Percents: map[string]float64{}})
+		counts[domain] = map[string]int{}
+	}
+
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); time.Sleep(interval) {
+		for i := range samples {
+			plr, err := GetPerfLimitReasons(cpu, samples[i].Domain)
+			if err != nil {
+				return samples, err
+			}
+
+			for _, reason := range plr.GetActive() {
+				counts[samples[i].Domain][reason]++
+			}
+			samples[i].Samples++
+		}
+	}
+
+	for i := range samples {
+		for _, reason := range samples[i].GetReasons() {
+			samples[i].Percents[reason] = 0
+			if samples[i].Samples > 0 {
+				samples[i].Percents[reason] = 100 * float64(counts[samples[i].Domain][reason]) / float64(samples[i].Samples)
+			}
+		}
+	}
+
+	return samples, nil
+}
@@ -21,12 +21,74 @@ import (
 // tuning utilities.
 
 const (
+	timeStampCounter = 0x10 // IA32_TIME_STAMP_COUNTER
+	smiCount         = 0x34 // b31:0 SMIs since reset
+	mperf            = 0xe7 // IA32_MPERF, counts at TSC rate while C0
+	aperf            = 0xe8 // IA32_APERF, counts at actual clock while C0
+	pkgCStConfig     = 0xe2 // b3:0 package C-state limit, b15 lock
+
+	// C-state residency counters all count at the TSC rate
+	coreC3Residency = 0x3fc
+	coreC6Residency = 0x3fd
+	coreC7Residency = 0x3fe
+	pkgC2Residency  = 0x60d
+	pkgC3Residency  = 0x3f8
+	pkgC6Residency  = 0x3f9
+	pkgC7Residency  = 0x3fa
+	pkgC8Residency  = 0x630
+	pkgC9Residency  = 0x631
+	pkgC10Residency = 0x632
+
+	platformInfo    = 0xce // Platform Info (ratios, programmability, b34:33 cTDP levels)
 	underVoltOffset = 0x150
+	perfStatus      = 0x198 // b15:8 current ratio, b47:32 core voltage
+	clockModulation = 0x19a // b4 on-demand enable, b3:0 duty cycle
+	thermInterrupt  = 0x19b // core thermal interrupt thresholds and enables
+	miscEnable      = 0x1a0 // b38 Turbo Mode Disable
 	tempOffset      = 0x1a2 // b29:24 Temperature Target
+	energyPerfBias  = 0x1b0 // b3:0 Energy Performance Bias hint (per thread)
+	pkgThermStatus  = 0x1b1 // Package Thermal Status (b22:16 readout below TjMax)
+	pkgThermInt     = 0x1b2 // package thermal interrupt thresholds and enables
+	powerCtl        = 0x1fc // b0 BD PROCHOT, b1 C1E enable
 	powerLimitUnits = 0x606 // Definition of units for 0x610
 	powerLimit      = 0x610 // PKG RAPL Power Limit Control (R/W)
+	pkgEnergyStatus = 0x611 // b31:0 package energy consumed, in energy status units
+	pkgPowerInfo    = 0x614 // PKG RAPL Parameters (b14:0 Thermal Spec Power)
+	uncoreRatio     = 0x620 // b6:0 max, b14:8 min uncore ratio
+	uncorePerf      = 0x621 // b6:0 current uncore ratio
+
+	turboRatioLimit  = 0x1ad // max ratio for 1-8 active cores, one byte each
+	turboRatioLimit1 = 0x1ae // 9-16 active cores
+	turboRatioLimit2 = 0x1af // 17-24 active cores
+
+	turboRatioLimitCores = 0x1ae // group sizes for 0x1ad, on parts that have groups
+
+	configTDPNominal     = 0x648 // b7:0 Config TDP Nominal ratio
+	configTDPLevel1      = 0x649 // Config TDP Level 1 ratio and power level
+	configTDPLevel2      = 0x64a // Config TDP Level 2 ratio and power level
+	configTDPControl     = 0x64b // b1:0 active Config TDP level, b31 lock
+	turboActivationRatio = 0x64c // b7:0 max non-turbo ratio, b31 lock
+
+	corePerfLimitReasons     = 0x64f // b15:0 status, b31:16 log (R/WC0)
+	graphicsPerfLimitReasons = 0x6b0
+	ringPerfLimitReasons     = 0x6b1
+
+	pmEnable        = 0x770 // b0 HWP enable (write once)
+	hwpCapabilities = 0x771 // HWP performance range
+	hwpRequest      = 0x774 // per-thread HWP request (min, max, desired, EPP, window)
 )
 
+// BusClockMHz is the reference clock that all of the ratio fields in the MSRs are multiplied
+// against. It's been fixed at 100MHz since Sandy Bridge, and nothing reports it directly:
+// PLATFORM_INFO only has ratios. GetBusClockMHz derives it where the CPU lets us, and this
+// is the fallback when it doesn't.
+const BusClockMHz = 100
+
+// RatioToMHz converts a ratio as found in the various frequency MSRs into a frequency in MHz
+func RatioToMHz(ratio int) int {
+	return ratio * BusClockMHz
+}
+
 // GetAllMsrFiles returns an array containing the /dev/cpu/XX/msr files for all CPUs on the
 // system.
 func GetAllMsrFiles() ([]string, error) {
@@ -52,7 +114,7 @@ func GetAllMsrFiles() ([]string, error) {
 
 
 func readMSRIntValue(msrFile string, MSRRegAddr int64) (uint64, error) {
-	log.Infof("reading value from %s:0x%x", msrFile, MSRRegAddr)
+	log.Debugf("reading value from %s:0x%x", msrFile, MSRRegAddr)
 	var ReturnValue uint64
 	bytesValue := make([]byte, 8)
 
@@ -60,6 +122,7 @@ func readMSRIntValue(msrFile string, MSRRegAddr int64) (uint64, error) {
 	if err != nil {
 		return ReturnValue, err
 	}
+	defer file.Close()
 
 	_, err = file.Seek(MSRRegAddr, 0)
 	if err != nil {
@@ -81,6 +144,16 @@ func readMSRIntValue(msrFile string, MSRRegAddr int64) (uint64, error) {
 	return ReturnValue, err
 }
 
+// readCPUMSR reads the 64-bit value of register MSRRegAddr on cpu
+func readCPUMSR(cpu int, MSRRegAddr int64) (uint64, error) {
+	return backend.ReadMSR(cpu, MSRRegAddr)
+}
+
+// writeCPUMSR writes value to register MSRRegAddr on cpu
+func writeCPUMSR(cpu int, MSRRegAddr int64, value uint64) error {
+	return backend.WriteMSR(cpu, MSRRegAddr, value)
+}
+
 // WriteMSRIntValue packs a uint64 into a byte array and writes said array to the MSR file
 // msr_file (i.e. for one spcific CPU) at location MSRRegAddr
 func WriteMSRIntValue(msrFile string, MSRRegAddr int64, value uint64) error {
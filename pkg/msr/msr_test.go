@@ -1,9 +1,55 @@
 package msr
 
 import (
+	"fmt"
+	"math"
 	"testing"
+	"time"
 )
 
+// fakeBackend is a set of registers shared by every CPU, for testing read-modify-writes.
+// Reading a register that hasn't been set is an error, like it is on the real thing.
+type fakeBackend map[int64]uint64
+
+func (f fakeBackend) ReadMSR(cpu int, reg int64) (uint64, error) {
+	buf, ok := f[reg]
+	if !ok {
+		return 0, fmt.Errorf("fake: no MSR 0x%x", reg)
+	}
+	return buf, nil
+}
+
+func (f fakeBackend) WriteMSR(cpu int, reg int64, value uint64) error {
+	if _, ok := f[reg]; !ok {
+		return fmt.Errorf("fake: no MSR 0x%x", reg)
+	}
+	f[reg] = value
+	return nil
+}
+
+func (f fakeBackend) CPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error) {
+	return [4]uint32{}, nil
+}
+
+// fakeCPU is a fakeBackend that reports signature (eax) for CPUID leaf 1
+type fakeCPU struct {
+	fakeBackend
+	signature uint32
+}
+
+func (f fakeCPU) CPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error) {
+	if leaf == 0x1 {
+		return [4]uint32{f.signature, 0, 0, 0}, nil
+	}
+	return [4]uint32{}, nil
+}
+
+// useFakeBackend points register access at f, and returns a func to undo that
+func useFakeBackend(f Backend) func() {
+	prev := SetBackend(f)
+	return func() { SetBackend(prev) }
+}
+
 func TestVoltageUnpacking(t *testing.T) {
 	t.Log("Testing voltage offset values")
 	m := map[uint64]int{
@@ -36,3 +82,393 @@ func TestVoltageUnpackAcrossRange(t *testing.T) {
 
 	}
 }
+
+func TestConfigTDPLevelUnpacking(t *testing.T) {
+	// 25W at ratio 0x14 with 1/8W power units, max power 44W, no min power
+	l := unpackConfigTDPLevel(2, 0x00000160001400c8, 0.125)
+
+	if l.Level != 2 || l.Ratio != 20 || l.TDP != 25 || l.MaxPower != 44 || l.MinPower != 0 {
+		t.Errorf("config TDP level unpacked incorrectly: %+v", l)
+	}
+
+	ctdp := ConfigTDP{levels: []ConfigTDPLevel{{Level: 0, TDP: 15}, {Level: 1, TDP: 10}, l}}
+	for name, want := range map[string]int{"nominal": 0, "down": 1, "up": 2} {
+		level, err := ctdp.GetLevelByName(name)
+		if err != nil || level != want {
+			t.Errorf("config TDP level '%s' resolves to %d (%v), should be %d", name, level, err, want)
+		}
+	}
+}
+
+func TestPerfLimitReasonsUnpacking(t *testing.T) {
+	// PL1 active, thermal and PL2 logged
+	active, logged := unpackPerfLimitReasons("core", 0x0000000008020400)
+	if len(active) != 1 || active[0] != "PL1" {
+		t.Errorf("active reasons unpacked to %v, should be [PL1]", active)
+	}
+	if len(logged) != 2 || logged[0] != "thermal" || logged[1] != "PL2" {
+		t.Errorf("logged reasons unpacked to %v, should be [thermal PL2]", logged)
+	}
+}
+
+func TestHWPRequestUnpacking(t *testing.T) {
+	// min 8, max 30, desired 0, EPP 128, window 5 * 10^3us
+	req := unpackHWPRequest(0x0000018580001e08)
+
+	if req.GetMinPerf() != 8 || req.GetMaxPerf() != 30 || req.GetDesiredPerf() != 0 || req.GetEPP() != 128 {
+		t.Errorf("HWP request unpacked incorrectly: %+v", req)
+	}
+	if req.GetWindow() != 5*time.Millisecond {
+		t.Errorf("HWP activity window unpacked to %s, should be 5ms", req.GetWindow())
+	}
+}
+
+func TestHWPWindowPacking(t *testing.T) {
+	m := map[time.Duration]int{
+		0:                       0,
+		100 * time.Microsecond:  100,
+		5 * time.Millisecond:    0x132, // 50 * 10^2us
+		1234 * time.Microsecond: 0x0fb, // rounds to 123 * 10^1us
+		time.Second:             0x264, // 100 * 10^4us
+	}
+
+	for window, field := range m {
+		packed, err := packHWPWindow(window)
+		if err != nil || packed != field {
+			t.Errorf("HWP window %s packed to %#x (%v), should be %#x", window, packed, err, field)
+		}
+	}
+
+	// 5ms round trips exactly; 1234us comes back as the 1230us the field can hold
+	if w, _ := packHWPWindow(5 * time.Millisecond); unpackHWPWindow(w) != 5*time.Millisecond {
+		t.Errorf("HWP window 5ms round tripped to %s", unpackHWPWindow(w))
+	}
+	if w, _ := packHWPWindow(1234 * time.Microsecond); unpackHWPWindow(w) != 1230*time.Microsecond {
+		t.Errorf("HWP window 1234us round tripped to %s, should be 1.23ms", unpackHWPWindow(w))
+	}
+
+	if _, err := packHWPWindow(time.Hour); err == nil {
+		t.Errorf("HWP window of an hour packed without error")
+	}
+}
+
+func TestTurboRatios(t *testing.T) {
+	// 4 cores: 4.6/4.6/4.4/4.2GHz
+	ratios := unpackTurboRatios([]uint64{0x2a2c2e2e})
+	if len(ratios) != 4 || ratios[0] != 46 || ratios[3] != 42 {
+		t.Errorf("turbo ratios unpacked to %v, should be [46 46 44 42]", ratios)
+	}
+
+	capped := capTurboRatios(0x2a2c2e2e, 44)
+	if capped != 0x2a2c2c2c {
+		t.Errorf("turbo ratios capped at 44 to 0x%x, should be 0x2a2c2c2c", capped)
+	}
+}
+
+func TestTurboRatioLayouts(t *testing.T) {
+	// Tiger Lake: one ratio per active core count, 0x1ae not read
+	regs := fakeBackend{platformInfo: 0x1800, turboRatioLimit: 0x2a2c2e2e, turboRatioLimit1: 0x2828}
+	restore := useFakeBackend(fakeCPU{regs, 0x806c1})
+	trl, err := GetTurboRatioLimit(0)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := trl.GetCoreCounts(); len(trl.GetRatios()) != 4 || len(counts) != 4 || counts[0] != 1 || counts[3] != 4 {
+		t.Errorf("per core turbo ratios %v are for %v active cores, should be 1-4", trl.GetRatios(), counts)
+	}
+
+	// Skylake SP: 0x1ae holds the group sizes, 2/4/8/16 cores
+	regs = fakeBackend{platformInfo: 0x1800, turboRatioLimit: 0x20222426, turboRatioLimitCores: 0x10080402}
+	restore = useFakeBackend(fakeCPU{regs, 0x50654})
+	trl, err = GetTurboRatioLimit(0)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ratios, counts := trl.GetRatios(), trl.GetCoreCounts(); len(ratios) != 4 || ratios[0] != 38 || len(counts) != 4 || counts[1] != 4 || counts[3] != 16 {
+		t.Errorf("grouped turbo ratios %v are for %v active cores, should be 2/4/8/16", ratios, counts)
+	}
+
+	// group sizes that don't go up aren't believed
+	if counts := unpackTurboGroups(0x02040810, 4); counts != nil {
+		t.Errorf("decreasing group sizes unpacked to %v, should be nil", counts)
+	}
+	if counts := unpackTurboGroups(0x0c080402, 3); len(counts) != 3 || counts[2] != 8 {
+		t.Errorf("first 3 group sizes unpacked to %v, should be [2 4 8]", counts)
+	}
+}
+
+func TestBusClock(t *testing.T) {
+	// i7-8550U: 1800 MHz base at ratio 18
+	if mhz := busClockMHz(1800, 18); mhz != 100 {
+		t.Errorf("bus clock for 1800 MHz at ratio 18 is %d MHz, should be 100", mhz)
+	}
+	// Core 2 era parts ran a 133 MHz bus, which leaf 0x16 can only round to whole MHz
+	if mhz := busClockMHz(2667, 20); mhz != 133 {
+		t.Errorf("bus clock for 2667 MHz at ratio 20 is %d MHz, should be 133", mhz)
+	}
+	if mhz := busClockMHz(0, 18); mhz != 0 {
+		t.Errorf("bus clock without a base frequency is %d MHz, should be 0", mhz)
+	}
+}
+
+func TestPlatformInfoUnpacking(t *testing.T) {
+	// i7-8550U: base 1.8GHz, efficiency 0.8GHz, min 0.4GHz, two config TDP levels, all
+	// limits programmable
+	pi := unpackPlatformInfo(0x0004080470011200)
+
+	if pi.GetMaxNonTurboRatio() != 18 || pi.GetMaxEfficiencyRatio() != 8 || pi.GetMinOperatingRatio() != 4 {
+		t.Errorf("platform info ratios unpacked incorrectly: %+v", pi)
+	}
+	if !pi.IsRatioProgrammable() || !pi.IsTDPProgrammable() || !pi.IsTCCOffsetProgrammable() {
+		t.Errorf("platform info programmable bits unpacked incorrectly: %+v", pi)
+	}
+	if pi.GetConfigTDPLevels() != 2 {
+		t.Errorf("platform info has %d config TDP levels, should be 2", pi.GetConfigTDPLevels())
+	}
+}
+
+func TestFrequencySample(t *testing.T) {
+	// one second at a 2GHz TSC, half of it busy at 3GHz
+	start := time.Now()
+	prev := FrequencyCounters{TSC: 1000, APERF: 500, MPERF: 200, Time: start}
+	cur := FrequencyCounters{TSC: 1000 + 2e9, APERF: 500 + 1.5e9, MPERF: 200 + 1e9, Time: start.Add(time.Second)}
+
+	s := CalcFrequencySample(prev, cur)
+	if s.TSCMHz != 2000 || s.AvgMHz != 1500 || s.Busy != 50 || s.BusyMHz != 3000 {
+		t.Errorf("frequency sample calculated incorrectly: %+v", s)
+	}
+
+	// APERF wrapping around shouldn't matter
+	prev.APERF = ^uint64(0) - 99
+	cur.APERF = 1.5e9 - 100
+	if s := CalcFrequencySample(prev, cur); s.AvgMHz != 1500 {
+		t.Errorf("frequency sample across APERF wrap calculated as %0.2f MHz, should be 1500", s.AvgMHz)
+	}
+}
+
+func TestCStateSample(t *testing.T) {
+	prev := CStateCounters{TSC: 0, Counters: map[string]uint64{"PC6": 100, "PC10": 0}}
+	cur := CStateCounters{TSC: 1000, Counters: map[string]uint64{"PC6": 350, "PC10": 600}}
+
+	s := CalcCStateSample(prev, cur)
+	if s.Residency["PC6"] != 25 || s.Residency["PC10"] != 60 {
+		t.Errorf("C-state residency calculated incorrectly: %v", s.Residency)
+	}
+}
+
+func TestPerfStatusUnpacking(t *testing.T) {
+	// ratio 34 at 0.95V (7782/8192)
+	ps := unpackPerfStatus(0x00001e6600002200)
+	if ps.Ratio != 34 || math.Abs(ps.Voltage-0.95) > 0.0001 {
+		t.Errorf("perf status unpacked to ratio %d at %0.4fV, should be ratio 34 at 0.95V", ps.Ratio, ps.Voltage)
+	}
+}
+
+func TestSMISampleWrap(t *testing.T) {
+	s := CalcSMISample(0, 0xfffffff0, 0x10, 2*time.Second)
+	if s.Count != 0x20 || s.Rate != 16 {
+		t.Errorf("SMI sample across wrap is %d (%0.1f/s), should be 32 (16.0/s)", s.Count, s.Rate)
+	}
+}
+
+func TestClockModulationPacking(t *testing.T) {
+	m := []struct {
+		duty     float64
+		extended bool
+		bits     uint64
+	}{
+		{100, false, 0x00}, // off
+		{50, false, 0x18},  // enable | 4<<1
+		{60, false, 0x1a},  // rounds to 62.5%, enable | 5<<1
+		{87.5, false, 0x1e},
+		// anything that rounds up to 8 steps is off rather than an overflowed field
+		{93.75, false, 0x00},
+		{95, false, 0x00},
+		{99, false, 0x00},
+		{99.9, false, 0x00},
+		{100, true, 0x00},
+		{50, true, 0x18}, // enable | 8
+		{93.75, true, 0x1f},
+		{95, true, 0x1f}, // rounds to 93.75%
+		{96.875, true, 0x00},
+		{99, true, 0x00},
+		{99.9, true, 0x00},
+	}
+
+	for _, c := range m {
+		bits, err := packClockModulation(c.duty, c.extended)
+		if err != nil || bits != c.bits {
+			t.Errorf("duty cycle %0.2f%% (extended:%t) packs to 0x%x (%v), should be 0x%x", c.duty, c.extended, bits, err, c.bits)
+		}
+	}
+
+	bits, _ := packClockModulation(6.25, true)
+	if enabled, duty := unpackClockModulation(bits, true); !enabled || duty != 6.25 {
+		t.Errorf("extended duty cycle 6.25%% packs and unpacks to %0.2f%% (enabled:%t)", duty, enabled)
+	}
+
+	if _, err := packClockModulation(0, false); err == nil {
+		t.Errorf("duty cycle of 0%% should not pack")
+	}
+}
+
+func TestThermalInterruptUnpacking(t *testing.T) {
+	// threshold 1 at 10 below TjMax (enabled), threshold 2 at 20 below (disabled), PLN on
+	thresholds, enabled, pln := unpackThermalInterrupt(0x01148a00)
+	if thresholds != [2]int{10, 20} || enabled != [2]bool{true, false} || !pln {
+		t.Errorf("thermal interrupt unpacks to %v %v pln:%t", thresholds, enabled, pln)
+	}
+}
+
+func TestTimeWindowPacking(t *testing.T) {
+	units := 1 / 1024.0 // 0x606 bits 19:16 = 0xa
+
+	bits, err := packTimeWindow(5, units)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2^12 * (1 + 1/4) / 1024 = 5s exactly
+	if bits != 0x2c {
+		t.Errorf("5s window packs to 0x%x, should be 0x2c", bits)
+	}
+	if window := unpackTimeWindow(bits, units); window != 5 {
+		t.Errorf("0x%x unpacks to %gs, should be 5s", bits, window)
+	}
+
+	if _, err := packTimeWindow(0.0001, units); err == nil {
+		t.Errorf("window shorter than one time unit should not pack")
+	}
+}
+
+func TestTemperatureTargetUnpacking(t *testing.T) {
+	// offset 0x3a, TjMax 100, clamping, window 0x2c
+	buf := uint64(0x3a6400ac)
+
+	offset, clamping, window := unpackTemperatureTarget(buf, 6, 1/1024.0)
+	if offset != 0x3a || !clamping || window != 5 {
+		t.Errorf("temperature target unpacks to offset %d clamping:%t window %gs", offset, clamping, window)
+	}
+
+	if offset, _, _ := unpackTemperatureTarget(buf, 4, 1/1024.0); offset != 0xa {
+		t.Errorf("4 bit offset unpacks to %d, should be 10", offset)
+	}
+
+	// Goldmont: 4 bit offset of 5, with reserved bits 29:28 set that a set has to leave be
+	f := fakeBackend{platformInfo: 1<<30 | 0x1800, tempOffset: 0x35640000}
+	defer useFakeBackend(fakeCPU{f, 0x506c9})()
+
+	tt, err := GetTempTarget(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tt.GetMaxOffset() != 15 || tt.GetThrottleTemp() != 95 {
+		t.Errorf("4 bit temperature target read as max offset %d, throttle temp %d", tt.GetMaxOffset(), tt.GetThrottleTemp())
+	}
+	if err := tt.SetThrottleTemp(90); err != nil {
+		t.Fatal(err)
+	}
+	if f[tempOffset] != 0x3a640000 {
+		t.Errorf("4 bit offset of 10 wrote 0x%x, should be 0x3a640000", f[tempOffset])
+	}
+}
+
+func TestPackagePowerWrap(t *testing.T) {
+	// 0x20 units of 1/16384 J over 2ms is just under 1W
+	if watts := CalcPackagePower(0xfffffff0, 0x10, 1/16384.0, 2*time.Millisecond); math.Abs(watts-0.9765625) > 1e-9 {
+		t.Errorf("package power across wrap is %gW, should be 0.9765625W", watts)
+	}
+}
+
+func TestPowerCtl(t *testing.T) {
+	// bd prochot and C1E on, plus undocumented bits that have to survive
+	pc := unpackPowerCtl(0x2904005f)
+	if !pc.IsBDProchotEnabled() || !pc.IsC1EEnabled() {
+		t.Errorf("power ctl unpacked incorrectly: %+v", pc)
+	}
+	if pc := unpackPowerCtl(0x2904005c); pc.IsBDProchotEnabled() || pc.IsC1EEnabled() {
+		t.Errorf("power ctl with bits 1:0 clear unpacked to %+v", pc)
+	}
+
+	f := fakeBackend{powerCtl: 0x2904005f}
+	defer useFakeBackend(f)()
+
+	pc, err := GetPowerCtl(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetBDProchot(false); err != nil {
+		t.Fatal(err)
+	}
+	if f[powerCtl] != 0x2904005e {
+		t.Errorf("disabling bd prochot wrote 0x%x, should be 0x2904005e", f[powerCtl])
+	}
+	if err := pc.SetC1E(false); err != nil {
+		t.Fatal(err)
+	}
+	if f[powerCtl] != 0x2904005c || pc.IsBDProchotEnabled() || pc.IsC1EEnabled() {
+		t.Errorf("disabling c1e wrote 0x%x (%+v), should be 0x2904005c", f[powerCtl], pc)
+	}
+	if err := pc.SetBDProchot(true); err != nil {
+		t.Fatal(err)
+	}
+	if f[powerCtl] != 0x2904005d {
+		t.Errorf("enabling bd prochot wrote 0x%x, should be 0x2904005d", f[powerCtl])
+	}
+}
+
+func TestPowerLimitProgrammable(t *testing.T) {
+	// PLATFORM_INFO bit 29 clear: that's 0x1ac, and has no say over 0x610
+	f := fakeBackend{platformInfo: 0x1800, powerLimitUnits: 0xa0e03, powerLimit: 0x42816000dc8078}
+	defer useFakeBackend(f)()
+
+	rpl, err := GetRAPLPowerLimit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpl.SetPowerLimit(20); err != nil {
+		t.Errorf("setting PL1 without the turbo TDP programmable bit failed: %s", err)
+	}
+	if f[powerLimit]&0xffff != 0x80a0 {
+		t.Errorf("PL1 of 20W wrote 0x%x, should be 0x...80a0", f[powerLimit])
+	}
+
+	// the lock bit is what stops writes
+	f[powerLimit] |= 1 << 63
+	if rpl, _ = GetRAPLPowerLimit(0); rpl.SetPowerLimit(25) == nil {
+		t.Errorf("set PL1 through the lock bit")
+	}
+}
+
+func TestUncoreRatioLimit(t *testing.T) {
+	// min 8 (800MHz), max 40 (4GHz)
+	url := unpackUncoreRatioLimit(0x0828)
+	if url.GetMinRatio() != 8 || url.GetMaxRatio() != 40 {
+		t.Errorf("uncore ratio limit unpacked to min %d max %d, should be 8 and 40", url.GetMinRatio(), url.GetMaxRatio())
+	}
+
+	// reserved bits above the fields have to survive the setters
+	f := fakeBackend{platformInfo: 0x1800, uncoreRatio: 0x100000828}
+	defer useFakeBackend(f)()
+
+	url, err := GetUncoreRatioLimit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ratio := url.MHzToRatio(3250); ratio != 32 || url.RatioToMHz(ratio) != 3200 {
+		t.Errorf("3250 MHz converts to ratio %d (%d MHz), should be 32 (3200 MHz)", ratio, url.RatioToMHz(ratio))
+	}
+	if err := url.SetMaxRatio(32); err != nil {
+		t.Fatal(err)
+	}
+	if err := url.SetMinRatio(12); err != nil {
+		t.Fatal(err)
+	}
+	if f[uncoreRatio] != 0x100000c20 {
+		t.Errorf("uncore limits of 12-32 wrote 0x%x, should be 0x100000c20", f[uncoreRatio])
+	}
+	if err := url.SetMinRatio(33); err == nil {
+		t.Errorf("set uncore min ratio above max")
+	}
+}
@@ -0,0 +1,133 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PlatformInfo is a struct corresponding to the MSR_PLATFORM_INFO MSR for a CPU. It tells us
+// the ratios the part was binned at and which of the limits we like to fiddle with are
+// actually programmable.
+type PlatformInfo struct {
+	cpu                   int
+	maxNonTurboRatio      int // base frequency
+	maxEfficiencyRatio    int
+	minOperatingRatio     int
+	ratioProgrammable     bool // turbo ratio limits are writable
+	tdpProgrammable       bool // turbo power/current limits (0x1ac) are writable
+	tccOffsetProgrammable bool // TCC activation offset in TEMPERATURE_TARGET is writable
+	configTDPLevels       int  // number of config TDP levels beyond nominal
+}
+
+// GetPlatformInfo returns a PlatformInfo struct for cpu
+func GetPlatformInfo(cpu int) (PlatformInfo, error) {
+	buf, err := readCPUMSR(cpu, platformInfo)
+	if err != nil {
+		return PlatformInfo{cpu: cpu}, err
+	}
+
+	pi := unpackPlatformInfo(buf)
+	pi.cpu = cpu
+	log.Debugf("platform info: %+v", pi)
+	return pi, nil
+}
+
+func unpackPlatformInfo(buf uint64) PlatformInfo {
+	pi := PlatformInfo{
+		maxNonTurboRatio:      int((buf >> 8) & 0xff),  // bits 15:8
+		ratioProgrammable:     (buf>>28)&0x1 == 1,      // bit 28
+		tdpProgrammable:       (buf>>29)&0x1 == 1,      // bit 29
+		tccOffsetProgrammable: (buf>>30)&0x1 == 1,      // bit 30
+		configTDPLevels:       int((buf >> 33) & 0x3),  // bits 34:33
+		maxEfficiencyRatio:    int((buf >> 40) & 0xff), // bits 47:40
+		minOperatingRatio:     int((buf >> 48) & 0xff), // bits 55:48
+	}
+
+	// 11b is reserved
+	if pi.configTDPLevels > 2 {
+		pi.configTDPLevels = 2
+	}
+
+	return pi
+}
+
+// GetBusClockMHz returns the bus clock of cpu in MHz, worked out from the base frequency
+// CPUID leaf 0x16 reports and the base ratio in PLATFORM_INFO. CPUs without leaf 0x16 (or
+// that leave it empty, as most hypervisors do) get BusClockMHz.
+func GetBusClockMHz(cpu int) (int, error) {
+	pi, err := GetPlatformInfo(cpu)
+	if err != nil {
+		return 0, fmt.Errorf("could not read platform info for CPU %d: %s", cpu, err)
+	}
+
+	regs, err := readCPUID(cpu, 0x0, 0)
+	if err != nil || regs[0] < 0x16 {
+		log.Debugf("no cpuid leaf 0x16 on cpu %d, assuming a %d MHz bus clock", cpu, BusClockMHz)
+		return BusClockMHz, nil
+	}
+
+	regs, err = readCPUID(cpu, 0x16, 0)
+	if err != nil {
+		log.Debugf("could not read cpuid leaf 0x16 on cpu %d, assuming a %d MHz bus clock: %s", cpu, BusClockMHz, err)
+		return BusClockMHz, nil
+	}
+
+	busClock := busClockMHz(int(regs[0]&0xffff), pi.GetMaxNonTurboRatio())
+	if busClock == 0 {
+		log.Debugf("no base frequency on cpu %d, assuming a %d MHz bus clock", cpu, BusClockMHz)
+		return BusClockMHz, nil
+	}
+
+	log.Debugf("bus clock on cpu %d: %d MHz", cpu, busClock)
+	return busClock, nil
+}
+
+// busClockMHz divides the base frequency by the base ratio, rounding to the nearest MHz
+// since the base frequency is only reported in whole MHz. 0 if either is missing.
+func busClockMHz(baseMHz int, baseRatio int) int {
+	if baseMHz == 0 || baseRatio == 0 {
+		return 0
+	}
+
+	return (baseMHz + baseRatio/2) / baseRatio
+}
+
+// GetMaxNonTurboRatio returns the max non-turbo (base) ratio
+func (p *PlatformInfo) GetMaxNonTurboRatio() int {
+	return p.maxNonTurboRatio
+}
+
+// GetMaxEfficiencyRatio returns the max efficiency ratio, i.e. the lowest the CPU will run
+// at under load before it starts trading efficiency for power
+func (p *PlatformInfo) GetMaxEfficiencyRatio() int {
+	return p.maxEfficiencyRatio
+}
+
+// GetMinOperatingRatio returns the lowest ratio the CPU supports. This is what PROCHOT pins
+// the CPU to.
+func (p *PlatformInfo) GetMinOperatingRatio() int {
+	return p.minOperatingRatio
+}
+
+// IsRatioProgrammable returns true if the turbo ratio limits can be written
+func (p *PlatformInfo) IsRatioProgrammable() bool {
+	return p.ratioProgrammable
+}
+
+// IsTDPProgrammable returns true if the turbo power and current limits in
+// MSR_TURBO_POWER_CURRENT_LIMIT (0x1ac) can be written. It says nothing about the RAPL
+// package power limits, which have their own lock bit.
+func (p *PlatformInfo) IsTDPProgrammable() bool {
+	return p.tdpProgrammable
+}
+
+// IsTCCOffsetProgrammable returns true if the TCC activation offset can be written
+func (p *PlatformInfo) IsTCCOffsetProgrammable() bool {
+	return p.tccOffsetProgrammable
+}
+
+// GetConfigTDPLevels returns the number of Config TDP levels supported beyond nominal
+func (p *PlatformInfo) GetConfigTDPLevels() int {
+	return p.configTDPLevels
+}
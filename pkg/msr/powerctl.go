@@ -0,0 +1,110 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	bdProchotBit = 0 // bidirectional PROCHOT
+	c1eBit       = 1 // C1E enable
+)
+
+// PowerCtl is a struct corresponding to the MSR_POWER_CTL MSR for a CPU.
+//
+// With bidirectional PROCHOT enabled, anything on the board (usually the EC, when it
+// dislikes the battery or the charger) can assert PROCHOT# and pin the CPU at its minimum
+// ratio. Turning it off means the CPU ignores the external signal but still asserts it
+// itself when hot.
+type PowerCtl struct {
+	cpu       int
+	bdProchot bool
+	c1e       bool
+}
+
+// GetPowerCtl returns a PowerCtl struct for cpu
+func GetPowerCtl(cpu int) (PowerCtl, error) {
+	pcBitfield, err := readCPUMSR(cpu, powerCtl)
+	if err != nil {
+		return PowerCtl{cpu: cpu}, err
+	}
+
+	pc := unpackPowerCtl(pcBitfield)
+	pc.cpu = cpu
+	log.Debugf("power ctl: bd prochot:%t c1e:%t", pc.bdProchot, pc.c1e)
+	return pc, nil
+}
+
+func unpackPowerCtl(pcBitfield uint64) PowerCtl {
+	return PowerCtl{
+		bdProchot: (pcBitfield>>bdProchotBit)&0x1 == 1,
+		c1e:       (pcBitfield>>c1eBit)&0x1 == 1,
+	}
+}
+
+// IsBDProchotEnabled returns true if the CPU honors externally asserted PROCHOT#
+func (p *PowerCtl) IsBDProchotEnabled() bool {
+	return p.bdProchot
+}
+
+// IsC1EEnabled returns true if C1E (enhanced halt) is enabled
+func (p *PowerCtl) IsC1EEnabled() bool {
+	return p.c1e
+}
+
+// SetBDProchot enables or disables bidirectional PROCHOT
+func (p *PowerCtl) SetBDProchot(enabled bool) error {
+	log.Infof("setting bd prochot to %t on cpu %d", enabled, p.cpu)
+	if enabled == p.bdProchot {
+		log.Debugf("bd prochot already %t. NOOP", enabled)
+		return nil
+	}
+
+	err := p.setBit(bdProchotBit, enabled)
+	if err != nil {
+		return err
+	}
+
+	p.bdProchot = enabled
+	return nil
+}
+
+// SetC1E enables or disables C1E
+func (p *PowerCtl) SetC1E(enabled bool) error {
+	log.Infof("setting c1e to %t on cpu %d", enabled, p.cpu)
+	if enabled == p.c1e {
+		log.Debugf("c1e already %t. NOOP", enabled)
+		return nil
+	}
+
+	err := p.setBit(c1eBit, enabled)
+	if err != nil {
+		return err
+	}
+
+	p.c1e = enabled
+	return nil
+}
+
+// setBit does a read-modify-write of a single bit in MSR_POWER_CTL. The rest of the
+// register is mostly undocumented, so we make sure to leave it exactly as we found it.
+func (p *PowerCtl) setBit(bit uint, enabled bool) error {
+	pcBitfield, err := readCPUMSR(p.cpu, powerCtl)
+	if err != nil {
+		return fmt.Errorf("could not read power ctl for CPU %d: %s", p.cpu, err)
+	}
+
+	if enabled {
+		pcBitfield |= 1 << bit
+	} else {
+		pcBitfield &^= 1 << bit
+	}
+
+	err = writeCPUMSR(p.cpu, powerCtl, pcBitfield)
+	if err != nil {
+		return fmt.Errorf("could not write power ctl for CPU %d: %s", p.cpu, err)
+	}
+
+	return nil
+}
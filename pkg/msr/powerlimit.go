@@ -1,7 +1,9 @@
 package msr
 
 import (
+	"fmt"
 	"math"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,6 +15,12 @@ type RAPLPowerLimit struct {
 	enabled    bool
 	clamping   bool    // no idea
 	timeWindow float64 // window of time (in s) over which limit is calculated
+	locked     bool    // bit 63, register is read-only until reset
+	powerUnits float64 // W per unit, from 0x606
+	timeUnits  float64 // s per unit, from 0x606
+
+	powerLimit2 float64 // short term package power limit (PL2) in W
+	enabled2    bool
 }
 
 // GetRAPLPowerLimit returns a RAPLPowerLimit struct for cpu
@@ -21,12 +29,7 @@ func GetRAPLPowerLimit(cpu int) (RAPLPowerLimit, error) {
 	// the units that we use in register 0x610, so we need to parse that first.
 	rpl := RAPLPowerLimit{cpu: cpu}
 
-	MSRFile, err := GetMsrFile(cpu)
-	if err != nil {
-		return rpl, err
-	}
-
-	rplUnitBitfield, err := readMSRIntValue(MSRFile, powerLimitUnits)
+	rplUnitBitfield, err := readCPUMSR(cpu, powerLimitUnits)
 	log.Debug()
 	if err != nil {
 		return rpl, err
@@ -34,21 +37,155 @@ func GetRAPLPowerLimit(cpu int) (RAPLPowerLimit, error) {
 
 	// powerUnits given in W, timeUnits in s
 	powerUnits, timeUnits := getRAPLPowerUnits(rplUnitBitfield)
+	rpl.powerUnits = powerUnits
+	rpl.timeUnits = timeUnits
 
-	rplBitfield, err := readMSRIntValue(MSRFile, powerLimit)
+	rplBitfield, err := readCPUMSR(cpu, powerLimit)
 	if err != nil {
 		return rpl, err
 	}
 
-	rpl.powerLimit = float64(rplBitfield&0x7fff) * powerUnits    // bits 14:0
-	rpl.timeWindow = float64((rplBitfield>>17)&0x7f) * timeUnits // bits 23:17
-	rpl.enabled = (rplBitfield>>15)&0x1 == 1                     // bit 15
-	rpl.clamping = (rplBitfield>>16)&0x1 == 1                    // bit 16
+	rpl.powerLimit = float64(rplBitfield&0x7fff) * powerUnits            // bits 14:0
+	rpl.timeWindow = unpackTimeWindow((rplBitfield>>17)&0x7f, timeUnits) // bits 23:17
+	rpl.enabled = (rplBitfield>>15)&0x1 == 1                             // bit 15
+	rpl.clamping = (rplBitfield>>16)&0x1 == 1                            // bit 16
+	rpl.locked = (rplBitfield>>63)&0x1 == 1                              // bit 63
 	log.Debugf("powerlimit: %0.2fW over %0.2fs enabled:%t clamping:%t", rpl.powerLimit, rpl.timeWindow, rpl.enabled, rpl.clamping)
 
+	rpl.powerLimit2 = float64((rplBitfield>>32)&0x7fff) * powerUnits // bits 46:32
+	rpl.enabled2 = (rplBitfield>>47)&0x1 == 1                        // bit 47
+	log.Debugf("powerlimit2: %0.2fW enabled:%t", rpl.powerLimit2, rpl.enabled2)
+
 	return rpl, nil
 }
 
+// GetPowerLimit returns the package power limit (PL1) in W and whether it's enabled
+func (r *RAPLPowerLimit) GetPowerLimit() (float64, bool) {
+	return r.powerLimit, r.enabled
+}
+
+// GetPowerLimit2 returns the short term package power limit (PL2) in W and whether it's
+// enabled
+func (r *RAPLPowerLimit) GetPowerLimit2() (float64, bool) {
+	return r.powerLimit2, r.enabled2
+}
+
+// GetTimeWindow returns the window (tau, in s) PL1 is averaged over
+func (r *RAPLPowerLimit) GetTimeWindow() float64 {
+	return r.timeWindow
+}
+
+// GetPowerUnits returns the granularity of the power limits in W
+func (r *RAPLPowerLimit) GetPowerUnits() float64 {
+	return r.powerUnits
+}
+
+// IsLocked returns true if the power limits can't be changed until the next reset
+func (r *RAPLPowerLimit) IsLocked() bool {
+	return r.locked
+}
+
+// SetPowerLimit sets the package power limit (PL1) to watts and enables it
+func (r *RAPLPowerLimit) SetPowerLimit(watts float64) error {
+	log.Infof("setting package power limit to %0.2fW on cpu %d", watts, r.cpu)
+	limit, err := r.setLimit(0, watts)
+	if err != nil {
+		return err
+	}
+
+	r.powerLimit = limit
+	r.enabled = true
+	return nil
+}
+
+// SetPowerLimit2 sets the short term package power limit (PL2) to watts and enables it
+func (r *RAPLPowerLimit) SetPowerLimit2(watts float64) error {
+	log.Infof("setting package power limit 2 to %0.2fW on cpu %d", watts, r.cpu)
+	limit, err := r.setLimit(32, watts)
+	if err != nil {
+		return err
+	}
+
+	r.powerLimit2 = limit
+	r.enabled2 = true
+	return nil
+}
+
+// RoundTimeWindow returns the time window SetTimeWindow would actually program for seconds
+func (r *RAPLPowerLimit) RoundTimeWindow(seconds float64) float64 {
+	bits, err := packTimeWindow(seconds, r.timeUnits)
+	if err != nil {
+		return seconds
+	}
+
+	return unpackTimeWindow(bits, r.timeUnits)
+}
+
+// SetTimeWindow sets the window PL1 is averaged over (tau) to seconds, rounded to the
+// nearest value the register can hold
+func (r *RAPLPowerLimit) SetTimeWindow(seconds float64) error {
+	log.Infof("setting package power limit time window to %gs on cpu %d", seconds, r.cpu)
+	if r.locked {
+		return fmt.Errorf("msr: package power limit is locked on CPU %d", r.cpu)
+	}
+
+	bits, err := packTimeWindow(seconds, r.timeUnits)
+	if err != nil {
+		return err
+	}
+
+	rplBitfield, err := readCPUMSR(r.cpu, powerLimit)
+	if err != nil {
+		return fmt.Errorf("could not read power limit for CPU %d: %s", r.cpu, err)
+	}
+
+	newBitfield := (rplBitfield &^ (0x7f << 17)) | bits<<17
+	if newBitfield == rplBitfield {
+		log.Debugf("power limit time window already %gs. NOOP", seconds)
+		return nil
+	}
+
+	err = writeCPUMSR(r.cpu, powerLimit, newBitfield)
+	if err != nil {
+		return fmt.Errorf("could not set power limit time window for CPU %d: %s", r.cpu, err)
+	}
+
+	r.timeWindow = unpackTimeWindow(bits, r.timeUnits)
+	return nil
+}
+
+// setLimit writes watts into the 15 bit limit field at shift and sets the enable bit right
+// above it, returning the limit actually programmed. The lock bit is the only thing that
+// says whether 0x610 can be written; PLATFORM_INFO's "TDP programmable" bit is about the
+// turbo power/current limit in 0x1ac, not RAPL.
+func (r *RAPLPowerLimit) setLimit(shift uint, watts float64) (float64, error) {
+	if watts <= 0 {
+		return 0, fmt.Errorf("msr: power limit must be positive")
+	}
+
+	if r.locked {
+		return 0, fmt.Errorf("msr: package power limit is locked on CPU %d", r.cpu)
+	}
+
+	units := uint64(math.Round(watts / r.powerUnits))
+	if units > 0x7fff {
+		return 0, fmt.Errorf("msr: power limit %0.2fW out of range", watts)
+	}
+
+	rplBitfield, err := readCPUMSR(r.cpu, powerLimit)
+	if err != nil {
+		return 0, fmt.Errorf("could not read power limit for CPU %d: %s", r.cpu, err)
+	}
+
+	rplBitfield = (rplBitfield &^ (0xffff << shift)) | (units|1<<15)<<shift
+	err = writeCPUMSR(r.cpu, powerLimit, rplBitfield)
+	if err != nil {
+		return 0, fmt.Errorf("could not set power limit for CPU %d: %s", r.cpu, err)
+	}
+
+	return float64(units) * r.powerUnits, nil
+}
+
 // getRAPLPowerUnits extracts the actual units in Watts and seconds from the 0x606 MSR register
 func getRAPLPowerUnits(rplUnitBitfield uint64) (float64, float64) {
 	// For power-related info, the units are (2^p)^-1 mW where p is the uint from 3:0 in
@@ -63,3 +200,29 @@ func getRAPLPowerUnits(rplUnitBitfield uint64) (float64, float64) {
 
 	return powerUnits, timeUnits
 }
+
+// unpackTimeWindow decodes a 7 bit RAPL-style time window field into seconds. The field
+// is a little floating point number: window = 2^Y * (1 + Z/4) * timeUnits, with Y in
+// bits 4:0 and Z in bits 6:5.
+func unpackTimeWindow(bits uint64, timeUnits float64) float64 {
+	y := float64(bits & 0x1f)
+	z := float64((bits >> 5) & 0x3)
+	return math.Pow(2, y) * (1 + z/4) * timeUnits
+}
+
+// packTimeWindow encodes seconds as a 7 bit RAPL-style time window field, picking the
+// closest value that can be represented
+func packTimeWindow(seconds float64, timeUnits float64) (uint64, error) {
+	if seconds < timeUnits || seconds > unpackTimeWindow(0x7f, timeUnits) {
+		return 0, fmt.Errorf("msr: time window %gs out of range [%gs, %gs]", seconds, timeUnits, unpackTimeWindow(0x7f, timeUnits))
+	}
+
+	var best uint64
+	for bits := uint64(0); bits <= 0x7f; bits++ {
+		if math.Abs(unpackTimeWindow(bits, timeUnits)-seconds) < math.Abs(unpackTimeWindow(best, timeUnits)-seconds) {
+			best = bits
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,81 @@
+package msr
+
+import (
+	"fmt"
+	"time"
+)
+
+// SMISample is the number of System Management Interrupts a CPU took over an interval.
+// SMIs are invisible to the OS otherwise; a climbing rate usually means the firmware (or
+// the EC behind it) is busy doing something, throttling included.
+type SMISample struct {
+	CPU   int
+	Count uint64
+	Rate  float64 // per second
+}
+
+// ReadSMICount returns the number of SMIs cpu has taken since reset
+func ReadSMICount(cpu int) (uint64, error) {
+	buf, err := readCPUMSR(cpu, smiCount)
+	if err != nil {
+		return 0, err
+	}
+
+	return buf & 0xffffffff, nil // bits 31:0
+}
+
+// CalcSMISample computes an SMISample from two counts taken elapsed apart. The counter is
+// only 32 bits, so handle it wrapping.
+func CalcSMISample(cpu int, prev uint64, cur uint64, elapsed time.Duration) SMISample {
+	sample := SMISample{CPU: cpu, Count: (cur - prev) & 0xffffffff}
+
+	if elapsed > 0 {
+		sample.Rate = float64(sample.Count) / elapsed.Seconds()
+	}
+
+	return sample
+}
+
+// SMISampler keeps the previous SMI count for a set of CPUs so that each call to Sample
+// reports on the interval since the last one
+type SMISampler struct {
+	cpus     []int
+	prev     map[int]uint64
+	prevTime time.Time
+}
+
+// NewSMISampler returns an SMISampler for cpus, primed with an initial count
+func NewSMISampler(cpus []int) (*SMISampler, error) {
+	ss := &SMISampler{cpus: cpus, prev: map[int]uint64{}}
+
+	for _, cpu := range cpus {
+		count, err := ReadSMICount(cpu)
+		if err != nil {
+			return nil, fmt.Errorf("msr: could not read SMI count on cpu %d: %s", cpu, err)
+		}
+		ss.prev[cpu] = count
+	}
+
+	ss.prevTime = time.Now()
+	return ss, nil
+}
+
+// Sample returns an SMISample for each CPU covering the time since the last call (or since
+// NewSMISampler)
+func (ss *SMISampler) Sample() ([]SMISample, error) {
+	var samples []SMISample
+
+	now := time.Now()
+	for _, cpu := range ss.cpus {
+		count, err := ReadSMICount(cpu)
+		if err != nil {
+			return samples, fmt.Errorf("msr: could not read SMI count on cpu %d: %s", cpu, err)
+		}
+
+		samples = append(samples, CalcSMISample(cpu, ss.prev[cpu], count, now.Sub(ss.prevTime)))
+		ss.prev[cpu] = count
+	}
+
+	ss.prevTime = now
+	return samples, nil
+}
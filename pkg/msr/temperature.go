@@ -6,9 +6,24 @@ import log "github.com/sirupsen/logrus"
 // TemperatureTarget is a struct corresponding to the TEMPERATURE_TARGET MSR for
 // an individual CPU
 type TemperatureTarget struct {
-	cpu    int // CPU number
-	target int // default thermal throttling activation temperature in degrees C
-	offset int // offset from the default in degrees C at which to start throttling
+	cpu         int     // CPU number
+	target      int     // default thermal throttling activation temperature in degrees C
+	offset      int     // offset from the default in degrees C at which to start throttling
+	offsetWidth uint    // bits in the offset field; 6 on most parts, 4 on some Atoms
+	clamping    bool    // bit 7, allow the offset to throttle below P1
+	window      float64 // bits 6:0, averaging time window (in s) for the offset
+	timeUnits   float64 // s per unit for the window, from 0x606
+}
+
+// tccOffset4BitModels are the family 6 models known to only have a 4 bit (bits 27:24) TCC
+// offset. Everything else gets the full 6 bits.
+var tccOffset4BitModels = map[int]bool{
+	0x5c: true, // Goldmont
+	0x5f: true, // Goldmont D
+	0x7a: true, // Goldmont Plus
+	0x86: true, // Tremont D
+	0x96: true, // Tremont
+	0x9c: true, // Tremont L
 }
 
 // GetThrottleTemp returns the throttle temperature calculated from the TemperatureTarget
@@ -17,6 +32,27 @@ func (t *TemperatureTarget) GetThrottleTemp() int {
 	return t.target - t.offset
 }
 
+// GetTjMax returns the default thermal throttling activation temperature (TjMax) in C
+func (t *TemperatureTarget) GetTjMax() int {
+	return t.target
+}
+
+// GetMaxOffset returns the largest offset below TjMax the CPU will accept
+func (t *TemperatureTarget) GetMaxOffset() int {
+	return 1<<t.offsetWidth - 1
+}
+
+// GetWindow returns the time window (in s) the offset is averaged over. 0 means the
+// offset applies instantaneously (or that the CPU doesn't support a window).
+func (t *TemperatureTarget) GetWindow() float64 {
+	return t.window
+}
+
+// IsClamping returns true if the offset is allowed to throttle below P1
+func (t *TemperatureTarget) IsClamping() bool {
+	return t.clamping
+}
+
 // SetThrottleTemp sets the throttle temperature for the CPU to temp by way of an offset
 // from TemperatureTarget.target (e.g. if t.target == 100, then setThrottleTemp(90)
 // will set t.offset to 10)
@@ -34,13 +70,22 @@ func (t *TemperatureTarget) SetThrottleTemp(throttleTemp int) error {
 		return nil
 	}
 
-	// we have a new value, now set it
-	MSRFile, err := GetMsrFile(t.cpu)
+	if newOffset > t.GetMaxOffset() {
+		return fmt.Errorf("CPU throttling temperature cannot be lower than %d (%d bit offset)", t.target-t.GetMaxOffset(), t.offsetWidth)
+	}
+
+	// Locked-down parts will silently ignore the write, so check before we pretend it worked
+	pi, err := GetPlatformInfo(t.cpu)
 	if err != nil {
-		return fmt.Errorf("could not get MSR file for CPU %d: %s", t.cpu, err)
+		return fmt.Errorf("could not read platform info for CPU %d: %s", t.cpu, err)
+	}
+	if !pi.IsTCCOffsetProgrammable() {
+		return fmt.Errorf("TCC offset is not programmable on CPU %d", t.cpu)
 	}
 
-	err = WriteMSRIntValue(MSRFile, tempOffset, uint64(newOffset<<24))
+	// we have a new value, now set it. Only touch the offset bits so we don't clobber the
+	// time window and clamping bit, or the reserved bits above a 4 bit offset.
+	err = t.update(uint64(t.GetMaxOffset())<<24, uint64(newOffset)<<24)
 	if err != nil {
 		return fmt.Errorf("could not set new offset for CPU %d: %s", t.cpu, err)
 	}
@@ -49,9 +94,52 @@ func (t *TemperatureTarget) SetThrottleTemp(throttleTemp int) error {
 	return nil
 }
 
+// SetWindow sets the time window the offset is averaged over to seconds, rounded to the
+// nearest value the register can hold, or turns averaging off if seconds is 0. Older CPUs
+// don't have a window at all and will refuse the write.
+func (t *TemperatureTarget) SetWindow(seconds float64) error {
+	log.Infof("setting throttle temp window to %gs on cpu %d", seconds, t.cpu)
+	var bits uint64
+	if seconds != 0 {
+		if t.timeUnits == 0 {
+			return fmt.Errorf("could not determine time units for CPU %d", t.cpu)
+		}
+
+		var err error
+		bits, err = packTimeWindow(seconds, t.timeUnits)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, _, window := unpackTemperatureTarget(bits, t.offsetWidth, t.timeUnits)
+	if window == t.window {
+		log.Debugf("throttle temp window already set to %gs. NOOP", window)
+		return nil
+	}
+
+	err := t.update(0x7f, bits)
+	if err != nil {
+		return fmt.Errorf("could not set offset window for CPU %d: %s", t.cpu, err)
+	}
+
+	t.window = window
+	return nil
+}
+
+// update does a read-modify-write of the bits in mask
+func (t *TemperatureTarget) update(mask uint64, value uint64) error {
+	buf, err := readCPUMSR(t.cpu, tempOffset)
+	if err != nil {
+		return err
+	}
+
+	return writeCPUMSR(t.cpu, tempOffset, (buf&^mask)|value)
+}
+
 // GetTempTarget returns a TemperatureTarget struct for the cpu given in cpu
 func GetTempTarget(cpu int) (TemperatureTarget, error) {
-	tempTarget := TemperatureTarget{cpu: cpu}
+	tempTarget := TemperatureTarget{cpu: cpu, offsetWidth: 6}
 
 	// Temp target offset calculation:
 	// Only the 29th-24th bits are relevant. Mask out 63rd-30th and shift right 24 bits
@@ -61,22 +149,124 @@ func GetTempTarget(cpu int) (TemperatureTarget, error) {
 	// 63    56 55    48 47    40 39    32 31    24 23    16 15     8 7      0
 	// 00000000 00000000 00000000 00000000 00010100 01100100 00000000 00000000
 	// mask: 00       00       00       00       7F       FF       FF       FF
-	var tempOffsetMask uint64 = 0x3fffffff
-
 	// Same thing with bits 23:16 for the temperature target (right shift 16)
 	var tempTargetMask uint64 = 0xffffff
 
-	MSRFile, err := GetMsrFile(cpu)
+	buf, err := readCPUMSR(cpu, tempOffset)
 	if err != nil {
 		return tempTarget, err
 	}
 
-	buf, err := readMSRIntValue(MSRFile, tempOffset)
+	// Some parts only have 4 bits of offset; if we can't tell, assume the usual 6
+	family, model, err := getCPUModel(cpu)
 	if err != nil {
-		return tempTarget, err
+		log.Infof("could not read cpu model on cpu %d, assuming 6 bit TCC offset: %s", cpu, err)
+	} else if family == 0x6 && tccOffset4BitModels[model] {
+		tempTarget.offsetWidth = 4
+	}
+
+	// The window uses the RAPL time units. Not every CPU with a TCC offset has RAPL, in
+	// which case there's no window to speak of either.
+	unitBuf, err := readCPUMSR(cpu, powerLimitUnits)
+	if err != nil {
+		log.Infof("could not read power units on cpu %d: %s", cpu, err)
+	} else {
+		_, tempTarget.timeUnits = getRAPLPowerUnits(unitBuf)
 	}
 
-	tempTarget.offset = int((buf & tempOffsetMask) >> 24)
 	tempTarget.target = int((buf & tempTargetMask) >> 16)
+	tempTarget.offset, tempTarget.clamping, tempTarget.window = unpackTemperatureTarget(buf, tempTarget.offsetWidth, tempTarget.timeUnits)
 	return tempTarget, nil
 }
+
+// unpackTemperatureTarget returns the offset, clamping bit and window (in s) from a
+// TEMPERATURE_TARGET value
+func unpackTemperatureTarget(buf uint64, offsetWidth uint, timeUnits float64) (int, bool, float64) {
+	offset := int((buf >> 24) & (1<<offsetWidth - 1)) // bits 29:24 (27:24)
+	clamping := (buf>>7)&0x1 == 1                     // bit 7
+
+	var window float64
+	if buf&0x7f != 0 {
+		window = unpackTimeWindow(buf&0x7f, timeUnits) // bits 6:0
+	}
+
+	return offset, clamping, window
+}
+
+// PackageThermalStatus is a struct corresponding to the IA32_PACKAGE_THERM_STATUS MSR. The
+// "log" bits are sticky and stay set until cleared, the others reflect the current state.
+type PackageThermalStatus struct {
+	cpu         int
+	readout     int  // degrees C below TjMax
+	thermal     bool // package is at or above the throttle temperature
+	thermalLog  bool
+	prochot     bool // PROCHOT# or FORCEPR# is being asserted externally
+	prochotLog  bool
+	critical    bool
+	criticalLog bool
+}
+
+// GetPackageThermalStatus returns a PackageThermalStatus struct for cpu
+func GetPackageThermalStatus(cpu int) (PackageThermalStatus, error) {
+	pts := PackageThermalStatus{cpu: cpu}
+
+	buf, err := readCPUMSR(cpu, pkgThermStatus)
+	if err != nil {
+		return pts, err
+	}
+
+	pts = unpackPackageThermalStatus(buf)
+	pts.cpu = cpu
+	return pts, nil
+}
+
+func unpackPackageThermalStatus(buf uint64) PackageThermalStatus {
+	return PackageThermalStatus{
+		thermal:     buf&0x1 == 1,            // bit 0
+		thermalLog:  (buf>>1)&0x1 == 1,       // bit 1
+		prochot:     (buf>>2)&0x1 == 1,       // bit 2
+		prochotLog:  (buf>>3)&0x1 == 1,       // bit 3
+		critical:    (buf>>4)&0x1 == 1,       // bit 4
+		criticalLog: (buf>>5)&0x1 == 1,       // bit 5
+		readout:     int((buf >> 16) & 0x7f), // bits 22:16
+	}
+}
+
+// GetPackageTemperature returns the package temperature of cpu in C, worked out from
+// TjMax and the package thermal status readout
+func GetPackageTemperature(cpu int) (int, error) {
+	tt, err := GetTempTarget(cpu)
+	if err != nil {
+		return 0, err
+	}
+
+	pts, err := GetPackageThermalStatus(cpu)
+	if err != nil {
+		return 0, err
+	}
+
+	return tt.GetTjMax() - pts.GetReadout(), nil
+}
+
+// GetReadout returns how many degrees C the package is below TjMax
+func (p *PackageThermalStatus) GetReadout() int {
+	return p.readout
+}
+
+// IsThrottling returns true if the package is currently thermally throttling, and whether
+// it has been since the log was last cleared
+func (p *PackageThermalStatus) IsThrottling() (bool, bool) {
+	return p.thermal, p.thermalLog
+}
+
+// IsProchotAsserted returns true if PROCHOT# is currently being asserted, and whether it
+// has been since the log was last cleared
+func (p *PackageThermalStatus) IsProchotAsserted() (bool, bool) {
+	return p.prochot, p.prochotLog
+}
+
+// IsCritical returns true if the package is at the critical temperature, and whether it
+// has been since the log was last cleared
+func (p *PackageThermalStatus) IsCritical() (bool, bool) {
+	return p.critical, p.criticalLog
+}
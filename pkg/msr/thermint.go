@@ -0,0 +1,153 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	threshold1Shift  = 8  // b14:8 threshold #1 (degrees below TjMax), b15 enable
+	threshold2Shift  = 16 // b22:16 threshold #2, b23 enable
+	plnEnableBit     = 24 // power limit notification enable
+	thresholdEnabled = 7  // enable bit, relative to the threshold's shift
+)
+
+// ThermalInterrupt is a struct corresponding to either the IA32_THERM_INTERRUPT (core) or
+// IA32_PACKAGE_THERM_INTERRUPT MSR for a CPU. Each has two programmable temperature
+// thresholds that raise an interrupt when crossed in either direction, which is how the
+// kernel (and anything listening to it) finds out about them.
+//
+// Note that the kernel's x86_pkg_temp_thermal driver uses the package thresholds for its
+// own trip points, so anything written there may get overwritten if that's loaded.
+type ThermalInterrupt struct {
+	cpu        int
+	pkg        bool
+	tjMax      int
+	thresholds [2]int  // degrees below TjMax
+	enabled    [2]bool // threshold interrupt enables
+	pln        bool    // power limit notification enable
+}
+
+func thermalInterruptRegister(pkg bool) int64 {
+	if pkg {
+		return pkgThermInt
+	}
+	return thermInterrupt
+}
+
+// GetThermalInterrupt returns a ThermalInterrupt struct for the package (pkg == true) or
+// core thermal interrupt MSR on cpu
+func GetThermalInterrupt(cpu int, pkg bool) (ThermalInterrupt, error) {
+	ti := ThermalInterrupt{cpu: cpu, pkg: pkg}
+
+	// The thresholds are relative to TjMax, which TEMPERATURE_TARGET already has for us
+	tt, err := GetTempTarget(cpu)
+	if err != nil {
+		return ti, err
+	}
+	ti.tjMax = tt.GetTjMax()
+
+	buf, err := readCPUMSR(cpu, thermalInterruptRegister(pkg))
+	if err != nil {
+		return ti, err
+	}
+
+	ti.thresholds, ti.enabled, ti.pln = unpackThermalInterrupt(buf)
+	log.Debugf("thermal interrupt (pkg:%t): thresholds %v enabled %v pln:%t", pkg, ti.thresholds, ti.enabled, ti.pln)
+	return ti, nil
+}
+
+func unpackThermalInterrupt(buf uint64) ([2]int, [2]bool, bool) {
+	var thresholds [2]int
+	var enabled [2]bool
+
+	for i, shift := range []uint{threshold1Shift, threshold2Shift} {
+		thresholds[i] = int((buf >> shift) & 0x7f)
+		enabled[i] = (buf>>(shift+thresholdEnabled))&0x1 == 1
+	}
+
+	return thresholds, enabled, (buf>>plnEnableBit)&0x1 == 1
+}
+
+// GetThreshold returns the temperature in C of threshold n (1 or 2) and whether its
+// interrupt is enabled
+func (t *ThermalInterrupt) GetThreshold(n int) (int, bool) {
+	if n < 1 || n > 2 {
+		return 0, false
+	}
+
+	return t.tjMax - t.thresholds[n-1], t.enabled[n-1]
+}
+
+// IsPowerLimitNotificationEnabled returns true if the power limit notification interrupt
+// is enabled
+func (t *ThermalInterrupt) IsPowerLimitNotificationEnabled() bool {
+	return t.pln
+}
+
+// SetThreshold sets threshold n (1 or 2) to temp C and enables or disables its interrupt
+func (t *ThermalInterrupt) SetThreshold(n int, temp int, enabled bool) error {
+	log.Infof("setting thermal threshold %d to %dC (enabled:%t) on cpu %d", n, temp, enabled, t.cpu)
+	if n < 1 || n > 2 {
+		return fmt.Errorf("msr: thermal threshold must be 1 or 2, not %d", n)
+	}
+
+	offset := t.tjMax - temp
+	if offset < 0 || offset > 0x7f {
+		return fmt.Errorf("msr: thermal threshold %dC must be between %dC and TjMax (%dC)", temp, t.tjMax-0x7f, t.tjMax)
+	}
+
+	shift := uint(threshold1Shift)
+	if n == 2 {
+		shift = threshold2Shift
+	}
+
+	var enableBit uint64
+	if enabled {
+		enableBit = 1
+	}
+
+	err := t.update(0xff<<shift, (uint64(offset)|enableBit<<thresholdEnabled)<<shift)
+	if err != nil {
+		return err
+	}
+
+	t.thresholds[n-1] = offset
+	t.enabled[n-1] = enabled
+	return nil
+}
+
+// SetPowerLimitNotification enables or disables the power limit notification interrupt
+func (t *ThermalInterrupt) SetPowerLimitNotification(enabled bool) error {
+	log.Infof("setting power limit notification to %t on cpu %d", enabled, t.cpu)
+
+	var bit uint64
+	if enabled {
+		bit = 1 << plnEnableBit
+	}
+
+	if err := t.update(1<<plnEnableBit, bit); err != nil {
+		return err
+	}
+
+	t.pln = enabled
+	return nil
+}
+
+// update does a read-modify-write of the bits in mask
+func (t *ThermalInterrupt) update(mask uint64, value uint64) error {
+	reg := thermalInterruptRegister(t.pkg)
+
+	buf, err := readCPUMSR(t.cpu, reg)
+	if err != nil {
+		return fmt.Errorf("could not read thermal interrupt for CPU %d: %s", t.cpu, err)
+	}
+
+	err = writeCPUMSR(t.cpu, reg, (buf&^mask)|value)
+	if err != nil {
+		return fmt.Errorf("could not write thermal interrupt for CPU %d: %s", t.cpu, err)
+	}
+
+	return nil
+}
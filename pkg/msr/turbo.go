@@ -0,0 +1,270 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const turboDisableBit = 38 // IA32_MISC_ENABLE
+
+// Each of these holds the max ratio for 8 active core counts, one per byte
+var turboRatioLimitRegisters = []int64{turboRatioLimit, turboRatioLimit1, turboRatioLimit2}
+
+// turboRatioLimitRatioModels are the family 6 models known to use 0x1ae and 0x1af as more
+// ratios, one per active core count like 0x1ad. Everything else only gets 0x1ad.
+var turboRatioLimitRatioModels = map[int]bool{
+	0x3e: true, // Ivy Bridge EP
+	0x3f: true, // Haswell EP
+	0x4f: true, // Broadwell EP
+	0x56: true, // Broadwell DE
+}
+
+// turboRatioLimitGroupModels are the family 6 models where 0x1ae is TURBO_RATIO_LIMIT_CORES:
+// each byte of 0x1ad is the max ratio for a group of cores rather than for one active core
+// count, and the same byte of 0x1ae is the most active cores that group covers. Those group
+// sizes mustn't be mistaken for ratios, let alone "capped".
+var turboRatioLimitGroupModels = map[int]bool{
+	0x55: true, // Skylake SP / Cascade Lake
+	0x5c: true, // Goldmont
+	0x5f: true, // Goldmont D
+	0x6a: true, // Ice Lake SP
+	0x6c: true, // Ice Lake D
+	0x86: true, // Tremont D
+	0x8f: true, // Sapphire Rapids
+	0x97: true, // Alder Lake
+	0x9a: true, // Alder Lake L
+	0xb7: true, // Raptor Lake
+	0xba: true, // Raptor Lake P
+	0xbf: true, // Raptor Lake S
+	0xcf: true, // Emerald Rapids
+}
+
+// TurboRatioLimit is a struct corresponding to the MSR_TURBO_RATIO_LIMIT MSRs for a CPU
+type TurboRatioLimit struct {
+	cpu        int
+	registers  []int64 // the registers ratios came from
+	ratios     []int   // ratios[i] is the max ratio with up to coreCounts[i] cores active
+	coreCounts []int   // nil if the ratios are per group and the group sizes are unknown
+	busClock   int     // MHz
+}
+
+// GetTurboRatioLimit returns a TurboRatioLimit struct for cpu. Only the first register is
+// guaranteed to exist; on the models in turboRatioLimitRatioModels the others are read if
+// they're there and stop at the first one that isn't (or is all zero, which is what small
+// parts report). On the models in turboRatioLimitGroupModels, the group sizes come from
+// 0x1ae. If the model can't be read, there's no telling which layout 0x1ad has, so it's
+// treated as groups of unknown size.
+func GetTurboRatioLimit(cpu int) (TurboRatioLimit, error) {
+	trl := TurboRatioLimit{cpu: cpu}
+
+	busClock, err := GetBusClockMHz(cpu)
+	if err != nil {
+		return trl, err
+	}
+	trl.busClock = busClock
+
+	registers := turboRatioLimitRegisters[:1]
+	grouped, groupSizes := false, false
+	if _, model, err := getCPUModel(cpu); err != nil {
+		log.Debugf("could not read cpu model, only reading turbo ratio limit 0x%x: %s", turboRatioLimit, err)
+		grouped = true
+	} else if turboRatioLimitRatioModels[model] {
+		registers = turboRatioLimitRegisters
+	} else if turboRatioLimitGroupModels[model] {
+		grouped, groupSizes = true, true
+	}
+
+	var bitfields []uint64
+	for i, reg := range registers {
+		buf, err := readCPUMSR(cpu, reg)
+		if err != nil {
+			if i == 0 {
+				return trl, err
+			}
+			log.Debugf("turbo ratio limit register 0x%x unavailable: %s", reg, err)
+			break
+		}
+
+		if buf == 0 {
+			break
+		}
+		bitfields = append(bitfields, buf)
+		trl.registers = append(trl.registers, reg)
+	}
+
+	trl.ratios = unpackTurboRatios(bitfields)
+
+	switch {
+	case !grouped:
+		for i := range trl.ratios {
+			trl.coreCounts = append(trl.coreCounts, i+1)
+		}
+	case groupSizes:
+		buf, err := readCPUMSR(cpu, turboRatioLimitCores)
+		if err != nil {
+			log.Debugf("turbo ratio limit cores register 0x%x unavailable: %s", turboRatioLimitCores, err)
+			break
+		}
+		trl.coreCounts = unpackTurboGroups(buf, len(trl.ratios))
+	}
+
+	log.Debugf("turbo ratio limits: %v for up to %v active cores", trl.ratios, trl.coreCounts)
+	return trl, nil
+}
+
+// unpackTurboGroups returns the most active cores each of the first n groups covers, from
+// TURBO_RATIO_LIMIT_CORES. The counts have to go up from group to group; if they don't, the
+// register isn't what we think it is and we return nil rather than make something up.
+func unpackTurboGroups(buf uint64, n int) []int {
+	var counts []int
+	for b := uint(0); b < uint(n) && b < 8; b++ {
+		count := int((buf >> (8 * b)) & 0xff)
+		if count == 0 || (len(counts) > 0 && count <= counts[len(counts)-1]) {
+			log.Debugf("turbo ratio limit cores 0x%x aren't increasing group sizes", buf)
+			return nil
+		}
+		counts = append(counts, count)
+	}
+
+	return counts
+}
+
+// unpackTurboRatios turns the turbo ratio limit registers into a list of ratios by active
+// core count. A zero byte means the part doesn't have that many cores, so we stop there.
+func unpackTurboRatios(bitfields []uint64) []int {
+	var ratios []int
+
+	for _, buf := range bitfields {
+		for b := uint(0); b < 8; b++ {
+			ratio := int((buf >> (8 * b)) & 0xff)
+			if ratio == 0 {
+				return ratios
+			}
+			ratios = append(ratios, ratio)
+		}
+	}
+
+	return ratios
+}
+
+// GetRatios returns the max turbo ratios, from the fewest active cores to the most. On parts
+// with one ratio per active core count, index 0 is 1 core active; see GetCoreCounts.
+func (t *TurboRatioLimit) GetRatios() []int {
+	return t.ratios
+}
+
+// GetCoreCounts returns, for each ratio, the most active cores it applies to. It's nil when
+// the ratios are per group of cores and the group sizes aren't known.
+func (t *TurboRatioLimit) GetCoreCounts() []int {
+	return t.coreCounts
+}
+
+// GetBusClockMHz returns the clock the ratios are multiplied against, in MHz
+func (t *TurboRatioLimit) GetBusClockMHz() int {
+	return t.busClock
+}
+
+// RatioToMHz converts a ratio into a frequency in MHz using the CPU's bus clock
+func (t *TurboRatioLimit) RatioToMHz(ratio int) int {
+	return ratio * t.busClock
+}
+
+// MHzToRatio converts a frequency in MHz into the highest ratio that doesn't exceed it
+func (t *TurboRatioLimit) MHzToRatio(mhz int) int {
+	return mhz / t.busClock
+}
+
+// SetMaxRatio caps the turbo ratio for every active core count at ratio. Core counts that
+// are already at or below ratio are left alone, so this can only lower limits; the
+// original values come back on reboot.
+func (t *TurboRatioLimit) SetMaxRatio(ratio int) error {
+	log.Infof("capping turbo ratios at %d on cpu %d", ratio, t.cpu)
+	if ratio <= 0 || ratio > 0xff {
+		return fmt.Errorf("msr: turbo ratio %d out of range [1, 255]", ratio)
+	}
+
+	pi, err := GetPlatformInfo(t.cpu)
+	if err != nil {
+		return fmt.Errorf("could not read platform info for CPU %d: %s", t.cpu, err)
+	}
+	if !pi.IsRatioProgrammable() {
+		return fmt.Errorf("msr: turbo ratio limits are not programmable on CPU %d", t.cpu)
+	}
+
+	// Only the registers we decoded as ratios get written
+	for _, reg := range t.registers {
+		buf, err := readCPUMSR(t.cpu, reg)
+		if err != nil {
+			return fmt.Errorf("could not read turbo ratio limit for CPU %d: %s", t.cpu, err)
+		}
+
+		newBuf := capTurboRatios(buf, ratio)
+		if newBuf == buf {
+			log.Debugf("turbo ratio limit 0x%x already at or below %d. NOOP", reg, ratio)
+			continue
+		}
+
+		err = writeCPUMSR(t.cpu, reg, newBuf)
+		if err != nil {
+			return fmt.Errorf("could not write turbo ratio limit for CPU %d: %s", t.cpu, err)
+		}
+	}
+
+	for i := range t.ratios {
+		if t.ratios[i] > ratio {
+			t.ratios[i] = ratio
+		}
+	}
+
+	return nil
+}
+
+// capTurboRatios lowers every nonzero byte in a turbo ratio limit register above ratio to ratio
+func capTurboRatios(buf uint64, ratio int) uint64 {
+	for b := uint(0); b < 8; b++ {
+		current := int((buf >> (8 * b)) & 0xff)
+		if current > ratio {
+			buf = (buf &^ (0xff << (8 * b))) | uint64(ratio)<<(8*b)
+		}
+	}
+
+	return buf
+}
+
+// IsTurboEnabled returns false if turbo has been disabled in IA32_MISC_ENABLE on cpu
+func IsTurboEnabled(cpu int) (bool, error) {
+	buf, err := readCPUMSR(cpu, miscEnable)
+	if err != nil {
+		return false, err
+	}
+
+	return (buf>>turboDisableBit)&0x1 == 0, nil
+}
+
+// SetTurboEnabled enables or disables turbo on cpu with a read-modify-write of
+// IA32_MISC_ENABLE. Everything else in that register is best left alone.
+func SetTurboEnabled(cpu int, enabled bool) error {
+	log.Infof("setting turbo to %t on cpu %d", enabled, cpu)
+	buf, err := readCPUMSR(cpu, miscEnable)
+	if err != nil {
+		return fmt.Errorf("could not read misc enable for CPU %d: %s", cpu, err)
+	}
+
+	newBuf := buf | 1<<turboDisableBit
+	if enabled {
+		newBuf = buf &^ (1 << turboDisableBit)
+	}
+
+	if newBuf == buf {
+		log.Debugf("turbo already %t. NOOP", enabled)
+		return nil
+	}
+
+	err = writeCPUMSR(cpu, miscEnable, newBuf)
+	if err != nil {
+		return fmt.Errorf("could not write misc enable for CPU %d: %s", cpu, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package msr
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UncoreRatioLimit is a struct corresponding to the MSR_UNCORE_RATIO_LIMIT MSR for a CPU,
+// which bounds the ring/LLC frequency
+type UncoreRatioLimit struct {
+	cpu      int
+	min      int
+	max      int
+	busClock int // MHz
+}
+
+// GetUncoreRatioLimit returns an UncoreRatioLimit struct for cpu
+func GetUncoreRatioLimit(cpu int) (UncoreRatioLimit, error) {
+	buf, err := readCPUMSR(cpu, uncoreRatio)
+	if err != nil {
+		return UncoreRatioLimit{cpu: cpu}, err
+	}
+
+	url := unpackUncoreRatioLimit(buf)
+	url.cpu = cpu
+
+	if url.busClock, err = GetBusClockMHz(cpu); err != nil {
+		return url, err
+	}
+
+	log.Debugf("uncore ratio limits: min %d max %d", url.min, url.max)
+	return url, nil
+}
+
+func unpackUncoreRatioLimit(buf uint64) UncoreRatioLimit {
+	return UncoreRatioLimit{
+		max: int(buf & 0x7f),        // bits 6:0
+		min: int((buf >> 8) & 0x7f), // bits 14:8
+	}
+}
+
+// GetUncoreRatio returns the ratio the uncore is currently running at. Not every CPU has
+// MSR_UNCORE_PERF_STATUS, so expect an error on older parts.
+func GetUncoreRatio(cpu int) (int, error) {
+	buf, err := readCPUMSR(cpu, uncorePerf)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(buf & 0x7f), nil // bits 6:0
+}
+
+// GetMinRatio returns the minimum uncore ratio
+func (u *UncoreRatioLimit) GetMinRatio() int {
+	return u.min
+}
+
+// GetMaxRatio returns the maximum uncore ratio
+func (u *UncoreRatioLimit) GetMaxRatio() int {
+	return u.max
+}
+
+// RatioToMHz converts a ratio into a frequency in MHz using the CPU's bus clock
+func (u *UncoreRatioLimit) RatioToMHz(ratio int) int {
+	return ratio * u.busClock
+}
+
+// MHzToRatio converts a frequency in MHz into the highest ratio that doesn't exceed it
+func (u *UncoreRatioLimit) MHzToRatio(mhz int) int {
+	return mhz / u.busClock
+}
+
+// SetMinRatio sets the minimum uncore ratio
+func (u *UncoreRatioLimit) SetMinRatio(ratio int) error {
+	log.Infof("setting uncore min ratio to %d on cpu %d", ratio, u.cpu)
+	if ratio > u.max {
+		return fmt.Errorf("msr: uncore min ratio %d is above max ratio %d", ratio, u.max)
+	}
+
+	if err := u.setField(8, ratio); err != nil {
+		return err
+	}
+
+	u.min = ratio
+	return nil
+}
+
+// SetMaxRatio sets the maximum uncore ratio
+func (u *UncoreRatioLimit) SetMaxRatio(ratio int) error {
+	log.Infof("setting uncore max ratio to %d on cpu %d", ratio, u.cpu)
+	if ratio < u.min {
+		return fmt.Errorf("msr: uncore max ratio %d is below min ratio %d", ratio, u.min)
+	}
+
+	if err := u.setField(0, ratio); err != nil {
+		return err
+	}
+
+	u.max = ratio
+	return nil
+}
+
+// setField does a read-modify-write of one of the 7 bit ratio fields
+func (u *UncoreRatioLimit) setField(shift uint, ratio int) error {
+	if ratio <= 0 || ratio > 0x7f {
+		return fmt.Errorf("msr: uncore ratio %d out of range [1, 127]", ratio)
+	}
+
+	buf, err := readCPUMSR(u.cpu, uncoreRatio)
+	if err != nil {
+		return fmt.Errorf("could not read uncore ratio limit for CPU %d: %s", u.cpu, err)
+	}
+
+	buf = (buf &^ (0x7f << shift)) | uint64(ratio)<<shift
+	err = writeCPUMSR(u.cpu, uncoreRatio, buf)
+	if err != nil {
+		return fmt.Errorf("could not write uncore ratio limit for CPU %d: %s", u.cpu, err)
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package msr
 import (
 	"fmt"
 	"math"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // VoltagePlanes is a simple map from a logical voltage plane name to its integer
@@ -16,19 +18,41 @@ var VoltagePlanes = map[string]int{
 }
 
 
-// SetVoltage sets the voltagePlane plane on cpu cpu to mVolts mV
-func SetVoltage(voltagePlane int, mVolts int, cpu int) error {
-	MSRFile, err := GetMsrFile(cpu)
+// PerfStatus is the ratio and voltage a CPU is running at right now, from IA32_PERF_STATUS.
+// Unlike the offsets, this is what the voltage regulator is actually being asked for.
+type PerfStatus struct {
+	CPU     int
+	Ratio   int
+	Voltage float64 // in V
+}
+
+// GetPerfStatus returns a PerfStatus struct for cpu
+func GetPerfStatus(cpu int) (PerfStatus, error) {
+	buf, err := readCPUMSR(cpu, perfStatus)
 	if err != nil {
-		return fmt.Errorf("msr: failed to set voltage on cpu %d: %s", cpu, err)
+		return PerfStatus{CPU: cpu}, fmt.Errorf("msr: could not read perf status on cpu %d: %s", cpu, err)
 	}
 
+	ps := unpackPerfStatus(buf)
+	ps.CPU = cpu
+	return ps, nil
+}
+
+func unpackPerfStatus(registerData uint64) PerfStatus {
+	// The voltage is a 16 bit fixed point number in units of 2^-13 V
+	return PerfStatus{
+		Ratio:   int((registerData >> 8) & 0xff),                      // bits 15:8
+		Voltage: float64((registerData>>32)&0xffff) / math.Pow(2, 13), // bits 47:32
+	}
+}
+
+// SetVoltage sets the voltagePlane plane on cpu cpu to mVolts mV
+func SetVoltage(voltagePlane int, mVolts int, cpu int) error {
 	OffsetValue := calcUndervoltValue(voltagePlane, mVolts)
-	fmt.Printf("OffsetValue: %#x\n", OffsetValue)
-	err = WriteMSRIntValue(MSRFile, underVoltOffset, OffsetValue)
+	log.Debugf("OffsetValue: %#x", OffsetValue)
+	err := writeCPUMSR(cpu, underVoltOffset, OffsetValue)
 	if err != nil {
-		fmt.Println("WriteMSRIntValue returned error: ", err)
-		return err
+		return fmt.Errorf("msr: failed to set voltage on cpu %d: %s", cpu, err)
 	}
 
 	return nil
@@ -36,23 +60,18 @@ func SetVoltage(voltagePlane int, mVolts int, cpu int) error {
 
 // GetVoltage gets the voltage offset in mV for the requested plane on the requested CPU
 func GetVoltage(voltagePlane int, cpu int) (int, error) {
-	MSRFile, err := GetMsrFile(cpu)
-	if err != nil {
-		return 0, fmt.Errorf("msr: failed to get msrfile cpu %d: %s", cpu, err)
-	}
-
 	// I think to read the value associated with a voltage plane, you have to write a
 	// "read" request (i.e. one without the write bit set) to the MSR and then turn
 	// around and read it.
 	//
 	// I have no idea what I'm doing.
 	readOffset := packOffset(0, voltagePlane, false)
-	err = WriteMSRIntValue(MSRFile, underVoltOffset, readOffset)
+	err := writeCPUMSR(cpu, underVoltOffset, readOffset)
 	if err != nil {
 		return 0, fmt.Errorf("msr: could not write read request to MSR: %s", err)
 	}
 
-	registerData, err := readMSRIntValue(MSRFile, underVoltOffset)
+	registerData, err := readCPUMSR(cpu, underVoltOffset)
 	if err != nil {
 		return 0, fmt.Errorf("msr: could not read value from MSR: %s", err)
 	}
@@ -0,0 +1,66 @@
+// Package power works out whether the machine is running on AC or battery from the
+// kernel's power_supply class.
+package power
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SysfsRoot is the directory containing the power supplies. It's a variable so that it can
+// be pointed at a fake tree for testing.
+var SysfsRoot = "/sys/class/power_supply"
+
+// The power sources GetSource can return. These double as the names of the profiles the
+// daemon switches between.
+const (
+	AC      = "ac"
+	Battery = "battery"
+)
+
+func readSupplyFile(supply string, file string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(SysfsRoot, supply, file))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(buf))
+}
+
+// GetSource returns AC if any mains (or USB-C) supply is online, and Battery otherwise. On
+// machines that don't report a mains supply at all, it goes by whether a battery is
+// discharging instead, and a machine with no supplies at all is assumed to be on AC.
+func GetSource() (string, error) {
+	supplies, err := ioutil.ReadDir(SysfsRoot)
+	if err != nil {
+		return "", err
+	}
+
+	var sawMains, online, discharging bool
+	for _, supply := range supplies {
+		name := supply.Name()
+
+		switch supplyType := readSupplyFile(name, "type"); supplyType {
+		case "Mains", "USB":
+			sawMains = true
+			if readSupplyFile(name, "online") == "1" {
+				log.Debugf("power supply %s (%s) is online", name, supplyType)
+				online = true
+			}
+		case "Battery":
+			if readSupplyFile(name, "status") == "Discharging" {
+				log.Debugf("battery %s is discharging", name)
+				discharging = true
+			}
+		}
+	}
+
+	if online || (!sawMains && !discharging) {
+		return AC, nil
+	}
+
+	return Battery, nil
+}
@@ -0,0 +1,46 @@
+package power
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSupply(t *testing.T, name string, files map[string]string) {
+	os.MkdirAll(filepath.Join(SysfsRoot, name), 0755)
+	for file, value := range files {
+		if err := ioutil.WriteFile(filepath.Join(SysfsRoot, name, file), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetSource(t *testing.T) {
+	root, err := ioutil.TempDir("", "power_supply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	SysfsRoot = root
+	if source, err := GetSource(); err != nil || source != AC {
+		t.Errorf("no power supplies is %s (%v), should be ac", source, err)
+	}
+
+	writeSupply(t, "BAT0", map[string]string{"type": "Battery", "status": "Discharging"})
+	if source, _ := GetSource(); source != Battery {
+		t.Errorf("discharging battery with no mains supply is %s, should be battery", source)
+	}
+
+	writeSupply(t, "AC", map[string]string{"type": "Mains", "online": "0"})
+	writeSupply(t, "BAT0", map[string]string{"status": "Full"})
+	if source, _ := GetSource(); source != Battery {
+		t.Errorf("offline mains supply is %s, should be battery", source)
+	}
+
+	writeSupply(t, "AC", map[string]string{"online": "1"})
+	if source, _ := GetSource(); source != AC {
+		t.Errorf("online mains supply is %s, should be ac", source)
+	}
+}
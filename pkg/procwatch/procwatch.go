@@ -0,0 +1,175 @@
+// Package procwatch matches the running processes against rules, so that the daemon can
+// switch profiles while a particular workload (a build, a game) is running.
+package procwatch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProcRoot is where procfs is mounted. It's a variable so that it can be pointed at a fake
+// tree for testing, or at the host's /proc from inside a container.
+var ProcRoot = "/proc"
+
+// Process is a running process, as far as the rules are concerned
+type Process struct {
+	PID    int
+	Exe    string // base name of the executable, e.g. "cargo"
+	Cgroup string // cgroup v2 path, e.g. "/user.slice/user-1000.slice/app.slice/steam.service"
+}
+
+// Scan returns the processes running now. Processes that exit part way through the scan
+// are skipped.
+func Scan() ([]Process, error) {
+	entries, err := ioutil.ReadDir(ProcRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []Process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		exe := readExe(pid)
+		if exe == "" {
+			continue
+		}
+
+		procs = append(procs, Process{PID: pid, Exe: exe, Cgroup: readCgroup(pid)})
+	}
+
+	log.Debugf("procwatch: scanned %d processes", len(procs))
+	return procs, nil
+}
+
+// readExe returns the base name of pid's executable. The exe link can only be read for our
+// own processes unless we're root, and not at all for kernel threads; comm (the first 15
+// characters of the name) is the fallback.
+func readExe(pid int) string {
+	dir := filepath.Join(ProcRoot, strconv.Itoa(pid))
+
+	if link, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		return filepath.Base(strings.TrimSuffix(link, " (deleted)"))
+	}
+
+	comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(comm))
+}
+
+// readCgroup returns pid's cgroup v2 path, or "" if it's not in one
+func readCgroup(pid int) string {
+	buf, err := ioutil.ReadFile(filepath.Join(ProcRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	// Each line is hierarchy-ID:controllers:path, and the unified hierarchy is 0 with no
+	// controllers
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::")
+		}
+	}
+
+	return ""
+}
+
+// Rule selects a profile while a matching process is running
+type Rule struct {
+	Profile  string
+	Exe      []string      // glob patterns for the executable's base name
+	Cgroup   []string      // glob patterns for the cgroup path
+	Priority int           // when several rules match, the highest priority wins
+	Linger   time.Duration // how long the rule stays in effect after the last match exits
+}
+
+// ValidatePattern returns an error if pattern isn't a valid glob
+func ValidatePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern '%s'", pattern)
+	}
+
+	return nil
+}
+
+// Matches returns true if p's executable or cgroup matches any of the rule's patterns
+func (r Rule) Matches(p Process) bool {
+	for _, pattern := range r.Exe {
+		if ok, _ := path.Match(pattern, p.Exe); ok {
+			return true
+		}
+	}
+
+	if p.Cgroup == "" {
+		return false
+	}
+
+	for _, pattern := range r.Cgroup {
+		if ok, _ := path.Match(pattern, p.Cgroup); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Match is a rule in effect, and the process that last matched it
+type Match struct {
+	Rule    Rule
+	Process Process
+	Seen    time.Time
+}
+
+// Watcher keeps track of which rules are in effect across scans
+type Watcher struct {
+	rules []Rule
+	seen  []Match // last match for each rule; the zero Match if it's never matched
+}
+
+// NewWatcher returns a Watcher for rules
+func NewWatcher(rules []Rule) *Watcher {
+	return &Watcher{rules: rules, seen: make([]Match, len(rules))}
+}
+
+// Update matches a scan taken at now against the rules, and returns the highest priority
+// rule in effect (one that matched in this scan, or within its linger), if any. Rules of
+// equal priority go by the order they were given in.
+func (w *Watcher) Update(procs []Process, now time.Time) (Match, bool) {
+	for i, r := range w.rules {
+		for _, p := range procs {
+			if r.Matches(p) {
+				w.seen[i] = Match{Rule: r, Process: p, Seen: now}
+				break
+			}
+		}
+	}
+
+	var best Match
+	var found bool
+	for i, m := range w.seen {
+		if m.Seen.IsZero() || now.Sub(m.Seen) > w.rules[i].Linger {
+			continue
+		}
+
+		if !found || m.Rule.Priority > best.Rule.Priority {
+			best, found = m, true
+		}
+	}
+
+	return best, found
+}
@@ -0,0 +1,75 @@
+package procwatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	ProcRoot = root
+
+	// readable exe link in a cgroup, comm only, and a non-process entry
+	os.MkdirAll(filepath.Join(root, "100"), 0755)
+	os.Symlink("/usr/bin/cargo", filepath.Join(root, "100", "exe"))
+	ioutil.WriteFile(filepath.Join(root, "100", "cgroup"), []byte("0::/user.slice/build.scope\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "200"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "200", "comm"), []byte("firefox\n"), 0644)
+	os.MkdirAll(filepath.Join(root, "sys"), 0755)
+
+	procs, err := Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(procs) != 2 {
+		t.Fatalf("scanned %+v, should be 2 processes", procs)
+	}
+	if p := procs[0]; p.PID != 100 || p.Exe != "cargo" || p.Cgroup != "/user.slice/build.scope" {
+		t.Errorf("process 100 scanned as %+v", p)
+	}
+	if p := procs[1]; p.PID != 200 || p.Exe != "firefox" || p.Cgroup != "" {
+		t.Errorf("process 200 scanned as %+v", p)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	w := NewWatcher([]Rule{
+		{Profile: "browse", Exe: []string{"firefox"}, Priority: 1},
+		{Profile: "build", Exe: []string{"cargo", "rustc"}, Priority: 10, Linger: 5 * time.Second},
+		{Profile: "game", Cgroup: []string{"/user.slice/*/app.slice/steam*"}, Priority: 10},
+	})
+	browser := Process{PID: 1, Exe: "firefox"}
+	cargo := Process{PID: 2, Exe: "cargo"}
+	game := Process{PID: 3, Exe: "wine", Cgroup: "/user.slice/user-1000.slice/app.slice/steam.service"}
+	start := time.Now()
+
+	if m, ok := w.Update([]Process{browser, cargo}, start); !ok || m.Rule.Profile != "build" || m.Process.PID != 2 {
+		t.Errorf("browser and cargo selected %+v (%t), should be build", m, ok)
+	}
+
+	// cargo has exited but is still lingering
+	if m, _ := w.Update([]Process{browser}, start.Add(3*time.Second)); m.Rule.Profile != "build" {
+		t.Errorf("selected %s 3s after cargo exited, should linger on build", m.Rule.Profile)
+	}
+
+	if m, _ := w.Update([]Process{browser}, start.Add(6*time.Second)); m.Rule.Profile != "browse" {
+		t.Errorf("selected %s 6s after cargo exited, should be browse", m.Rule.Profile)
+	}
+
+	// equal priorities go by rule order
+	if m, _ := w.Update([]Process{game, cargo}, start.Add(7*time.Second)); m.Rule.Profile != "build" {
+		t.Errorf("game and cargo selected %s, should be build", m.Rule.Profile)
+	}
+
+	if m, ok := w.Update(nil, start.Add(20*time.Second)); ok {
+		t.Errorf("selected %s with nothing running", m.Rule.Profile)
+	}
+}
@@ -0,0 +1,348 @@
+// Package sim is a simulated CPU package that msr can run against in place of /dev/cpu (see
+// msr.SetBackend). It models just enough to exercise the power and thermal code: RAPL caps
+// what a workload can draw, the energy counter accumulates what it did draw, and the package
+// heats and cools like an RC circuit towards ambient plus power times thermal resistance.
+//
+// Time is virtual and only moves when Advance is called, so anything run against a Sim
+// (e.g. control.Controller, which takes its time from msr.Now) is deterministic.
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// The registers the simulator models. Anything else has to be set with SetRegister first,
+// and then just holds whatever is written to it.
+const (
+	platformInfo    = 0xce
+	tempTarget      = 0x1a2
+	pkgThermStatus  = 0x1b1
+	powerLimitUnits = 0x606
+	powerLimit      = 0x610
+	pkgEnergyStatus = 0x611
+	pkgPowerInfo    = 0x614
+)
+
+// Units advertised in 0x606: 1/8 W, 1/16384 J (~61uJ) and 1/1024 s, as on most client parts
+const (
+	powerUnitBits  = 3
+	energyUnitBits = 14
+	timeUnitBits   = 10
+)
+
+// cpuModel is what CPUID leaf 1 reports: family 6 model 0x8c (Tiger Lake), which has a
+// 6 bit TCC offset
+const cpuModel = 0x000806c1
+
+// Workload returns the power in W the package would draw at t into the simulation if
+// nothing were limiting it
+type Workload func(t time.Duration) float64
+
+// Constant is a workload that always wants watts
+func Constant(watts float64) Workload {
+	return func(time.Duration) float64 { return watts }
+}
+
+// Step is a workload that wants before until at, and after from then on
+func Step(before float64, after float64, at time.Duration) Workload {
+	return func(t time.Duration) float64 {
+		if t < at {
+			return before
+		}
+		return after
+	}
+}
+
+// Config describes the simulated package
+type Config struct {
+	CPUs         int           // logical CPUs, all in the one package
+	TjMax        int           // C
+	Ambient      float64       // C
+	Resistance   float64       // junction to ambient, in C per W
+	TimeConstant time.Duration // thermal RC time constant
+	PL1          float64       // W, the power-on PL1 (and TDP)
+	PL2          float64       // W, the power-on PL2
+	Tau          time.Duration // the power-on PL1 time window
+	Step         time.Duration // Advance moves time on in steps of this
+}
+
+// DefaultConfig returns a config that looks roughly like a 15W laptop part
+func DefaultConfig() Config {
+	return Config{
+		CPUs:         4,
+		TjMax:        100,
+		Ambient:      25,
+		Resistance:   2.5,
+		TimeConstant: 10 * time.Second,
+		PL1:          15,
+		PL2:          25,
+		Tau:          28 * time.Second,
+		Step:         10 * time.Millisecond,
+	}
+}
+
+// Validate checks the config makes sense
+func (c Config) Validate() error {
+	switch {
+	case c.CPUs <= 0:
+		return fmt.Errorf("sim: need at least one CPU")
+	case c.TjMax <= 0 || float64(c.TjMax) <= c.Ambient:
+		return fmt.Errorf("sim: TjMax must be above ambient")
+	case c.Resistance <= 0 || c.TimeConstant <= 0:
+		return fmt.Errorf("sim: thermal resistance and time constant must be positive")
+	case c.PL1 <= 0 || c.PL2 < c.PL1:
+		return fmt.Errorf("sim: power limits [%gW, %gW] are invalid", c.PL1, c.PL2)
+	case c.Tau <= 0 || c.Step <= 0:
+		return fmt.Errorf("sim: tau and step must be positive")
+	}
+
+	return nil
+}
+
+// Sim is a simulated package. It implements msr.Backend and msr.Clock.
+type Sim struct {
+	mu       sync.Mutex
+	cfg      Config
+	workload Workload
+	start    time.Time
+	elapsed  time.Duration
+
+	temp    float64 // C
+	power   float64 // W drawn over the last step
+	average float64 // W, running average over tau that PL1 is enforced against
+	energy  float64 // J since the start
+
+	throttling bool
+	thermalLog bool // sticky until cleared through 0x1b1
+
+	regs map[int64]uint64 // writable registers, all package scoped
+}
+
+// New returns a Sim for cfg, idle and at ambient temperature
+func New(cfg Config) (*Sim, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Sim{
+		cfg:      cfg,
+		workload: Constant(0),
+		start:    time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		temp:     cfg.Ambient,
+		regs:     make(map[int64]uint64),
+	}
+
+	tau := packTimeWindow(cfg.Tau.Seconds())
+	s.regs[powerLimit] = powerUnits(cfg.PL1) | 1<<15 | 1<<16 | tau<<17 | // PL1, enabled, clamped
+		(powerUnits(cfg.PL2)|1<<15)<<32 // PL2, enabled
+
+	return s, nil
+}
+
+// SetWorkload changes what the package is asked to draw from now on
+func (s *Sim) SetWorkload(w Workload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workload = w
+}
+
+// SetRegister sets reg to value, e.g. to lock 0x610 or to give a register the simulator
+// doesn't otherwise model a value
+func (s *Sim) SetRegister(reg int64, value uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regs[reg] = value
+}
+
+// Now returns the simulated time
+func (s *Sim) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.start.Add(s.elapsed)
+}
+
+// Temperature returns the package temperature in C, unrounded
+func (s *Sim) Temperature() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.temp
+}
+
+// Power returns the power in W the package drew over the last step
+func (s *Sim) Power() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.power
+}
+
+// Advance moves simulated time on by d
+func (s *Sim) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for d > 0 {
+		dt := s.cfg.Step
+		if d < dt {
+			dt = d
+		}
+		s.step(dt)
+		d -= dt
+	}
+}
+
+// step runs the model forward by dt
+func (s *Sim) step(dt time.Duration) {
+	seconds := dt.Seconds()
+	limits := s.regs[powerLimit]
+	pl1, pl1Enabled := float64(limits&0x7fff)/(1<<powerUnitBits), (limits>>15)&0x1 == 1
+	pl2, pl2Enabled := float64((limits>>32)&0x7fff)/(1<<powerUnitBits), (limits>>47)&0x1 == 1
+	tau := unpackTimeWindow((limits >> 17) & 0x7f)
+
+	p := math.Max(0, s.workload(s.elapsed))
+	if pl2Enabled {
+		p = math.Min(p, pl2)
+	}
+	// RAPL lets the package run past PL1 until the average over tau catches up with it
+	if pl1Enabled && s.average >= pl1 {
+		p = math.Min(p, pl1)
+	}
+
+	// At the throttle temperature the TCC cuts the clocks back to whatever holds it there
+	throttleTemp := float64(s.cfg.TjMax - int((s.regs[tempTarget]>>24)&0x3f))
+	s.throttling = s.temp >= throttleTemp
+	if s.throttling {
+		p = math.Min(p, math.Max(0, (throttleTemp-s.cfg.Ambient)/s.cfg.Resistance))
+		s.thermalLog = true
+	}
+
+	s.power = p
+	s.energy += p * seconds
+	if tau > 0 {
+		s.average += (p - s.average) * math.Min(1, seconds/tau)
+	}
+	steady := s.cfg.Ambient + p*s.cfg.Resistance
+	s.temp += (steady - s.temp) * math.Min(1, seconds/s.cfg.TimeConstant.Seconds())
+	s.elapsed += dt
+}
+
+// ReadMSR returns the value of reg. Every CPU sees the same package.
+func (s *Sim) ReadMSR(cpu int, reg int64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkCPU(cpu); err != nil {
+		return 0, err
+	}
+
+	switch reg {
+	case platformInfo:
+		// base ratio of 24, turbo ratios, TDP and TCC offset all programmable
+		return 24<<8 | 1<<28 | 1<<29 | 1<<30, nil
+	case tempTarget:
+		return s.regs[tempTarget] | uint64(s.cfg.TjMax)<<16, nil
+	case pkgThermStatus:
+		readout := math.Max(0, math.Min(0x7f, float64(s.cfg.TjMax)-math.Round(s.temp)))
+		buf := uint64(readout)<<16 | 1<<31 // reading valid
+		if s.throttling {
+			buf |= 1 << 0
+		}
+		if s.thermalLog {
+			buf |= 1 << 1
+		}
+		return buf, nil
+	case powerLimitUnits:
+		return timeUnitBits<<16 | energyUnitBits<<8 | powerUnitBits, nil
+	case pkgEnergyStatus:
+		return uint64(s.energy*(1<<energyUnitBits)) & 0xffffffff, nil
+	case pkgPowerInfo:
+		return powerUnits(s.cfg.PL1), nil
+	}
+
+	buf, ok := s.regs[reg]
+	if !ok {
+		return 0, fmt.Errorf("sim: MSR 0x%x is not simulated", reg)
+	}
+	return buf, nil
+}
+
+// WriteMSR writes value to reg, honouring which bits are writable and the 0x610 lock
+func (s *Sim) WriteMSR(cpu int, reg int64, value uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkCPU(cpu); err != nil {
+		return err
+	}
+
+	switch reg {
+	case tempTarget:
+		s.regs[tempTarget] = value & (0x3f<<24 | 0xff) // offset, clamping and window
+		return nil
+	case pkgThermStatus:
+		// the log bits are cleared by writing 0, and the rest are read only
+		if value&(1<<1) == 0 {
+			s.thermalLog = false
+		}
+		return nil
+	case powerLimit:
+		if (s.regs[powerLimit]>>63)&0x1 == 1 {
+			return fmt.Errorf("sim: MSR 0x%x is locked", reg)
+		}
+		s.regs[powerLimit] = value
+		return nil
+	case platformInfo, powerLimitUnits, pkgEnergyStatus, pkgPowerInfo:
+		return fmt.Errorf("sim: MSR 0x%x is read only", reg)
+	}
+
+	if _, ok := s.regs[reg]; !ok {
+		return fmt.Errorf("sim: MSR 0x%x is not simulated", reg)
+	}
+	s.regs[reg] = value
+	return nil
+}
+
+// CPUID returns the registers for leaf 1 (the CPU model). Every other leaf is zeroes.
+func (s *Sim) CPUID(cpu int, leaf uint32, subleaf uint32) ([4]uint32, error) {
+	var regs [4]uint32
+	if err := s.checkCPU(cpu); err != nil {
+		return regs, err
+	}
+
+	if leaf == 0x1 {
+		regs[0] = cpuModel
+	}
+	return regs, nil
+}
+
+func (s *Sim) checkCPU(cpu int) error {
+	if cpu < 0 || cpu >= s.cfg.CPUs {
+		return fmt.Errorf("sim: invalid CPU number %d", cpu)
+	}
+	return nil
+}
+
+// powerUnits converts watts to 0x610 power units
+func powerUnits(watts float64) uint64 {
+	return uint64(math.Round(watts*(1<<powerUnitBits))) & 0x7fff
+}
+
+// unpackTimeWindow converts 0x610's bits 23:17 to s: 2^Y * (1 + Z/4) time units, with Y in
+// the low 5 bits and Z in the high 2
+func unpackTimeWindow(bits uint64) float64 {
+	y, z := bits&0x1f, (bits>>5)&0x3
+	return math.Pow(2, float64(y)) * (1 + float64(z)/4) / (1 << timeUnitBits)
+}
+
+// packTimeWindow returns the time window bits closest to seconds
+func packTimeWindow(seconds float64) uint64 {
+	var best uint64
+	for bits := uint64(0); bits <= 0x7f; bits++ {
+		if math.Abs(unpackTimeWindow(bits)-seconds) < math.Abs(unpackTimeWindow(best)-seconds) {
+			best = bits
+		}
+	}
+	return best
+}
@@ -0,0 +1,156 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/davidr/ddtp/pkg/control"
+	"github.com/davidr/ddtp/pkg/msr"
+)
+
+// newSim returns a Sim with the msr package pointed at it, and a func to undo that
+func newSim(t *testing.T, cfg Config) (*Sim, func()) {
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := msr.SetBackend(s)
+	return s, func() { msr.SetBackend(prev) }
+}
+
+func TestSteadyState(t *testing.T) {
+	s, restore := newSim(t, DefaultConfig())
+	defer restore()
+
+	s.SetWorkload(Constant(10))
+	s.Advance(5 * time.Minute)
+
+	sampler, err := msr.NewPackagePowerSampler(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Advance(10 * time.Second)
+
+	power, err := sampler.Sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(power-10) > 0.01 {
+		t.Errorf("package drew %.2fW, should be 10W", power)
+	}
+
+	temp, err := msr.GetPackageTemperature(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temp != 50 {
+		t.Errorf("package is at %dC, should settle at 25C + 10W * 2.5C/W = 50C", temp)
+	}
+}
+
+func TestPowerLimits(t *testing.T) {
+	s, restore := newSim(t, DefaultConfig())
+	defer restore()
+
+	s.SetWorkload(Constant(40))
+	s.Advance(time.Second)
+	if p := s.Power(); p != 25 {
+		t.Errorf("package is drawing %.2fW at first, should be held to PL2 (25W)", p)
+	}
+
+	s.Advance(2 * time.Minute)
+	if p := s.Power(); p != 15 {
+		t.Errorf("package is drawing %.2fW after tau, should be held to PL1 (15W)", p)
+	}
+
+	rpl, err := msr.GetRAPLPowerLimit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpl.SetPowerLimit(10); err != nil {
+		t.Fatal(err)
+	}
+	s.Advance(time.Second)
+	if p := s.Power(); p != 10 {
+		t.Errorf("package is drawing %.2fW, should be held to the new PL1 (10W)", p)
+	}
+
+	s.SetRegister(powerLimit, 1<<63)
+	if err := rpl.SetPowerLimit(20); err == nil {
+		t.Errorf("set PL1 with the register locked")
+	}
+}
+
+func TestThrottling(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PL1 = cfg.PL2
+	s, restore := newSim(t, cfg)
+	defer restore()
+
+	tt, err := msr.GetTempTarget(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.SetThrottleTemp(70); err != nil {
+		t.Fatal(err)
+	}
+
+	// 25W would settle at 87.5C, well past the 70C throttle temperature
+	s.SetWorkload(Constant(25))
+	s.Advance(5 * time.Minute)
+
+	if temp := s.Temperature(); math.Abs(temp-70) > 0.5 {
+		t.Errorf("package is at %.2fC, should be held at 70C", temp)
+	}
+
+	pts, err := msr.GetPackageThermalStatus(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, log := pts.IsThrottling(); !log {
+		t.Errorf("package thermal status doesn't show throttling")
+	}
+
+	// Back down to idle: the log stays set until it's cleared
+	s.SetWorkload(Constant(0))
+	s.Advance(time.Minute)
+	if buf, _ := s.ReadMSR(0, pkgThermStatus); buf&0x3 != 0x2 {
+		t.Errorf("package thermal status is 0x%x, should be logged but not throttling", buf)
+	}
+
+	s.WriteMSR(0, pkgThermStatus, 0)
+	if buf, _ := s.ReadMSR(0, pkgThermStatus); buf&0x3 != 0 {
+		t.Errorf("package thermal status is 0x%x after clearing the log", buf)
+	}
+}
+
+func TestController(t *testing.T) {
+	s, restore := newSim(t, DefaultConfig())
+	defer restore()
+
+	// 40W wanted, 15W PL1 settles at 62.5C: the controller should find the 18W that holds
+	// it at 70C
+	s.SetWorkload(Constant(40))
+	cfg := control.Config{Target: 70, MinPL1: 5, MaxPL1: 25, Kp: 0.5, Ki: 0.05, MaxRate: 2, Interval: time.Second}
+	c, err := control.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d control.Decision
+	for i := 0; i < 600; i++ {
+		s.Advance(cfg.Interval)
+		if d, err = c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if temp := s.Temperature(); math.Abs(temp-70) > 1 {
+		t.Errorf("package is at %.2fC after 10 minutes, should be held at 70C", temp)
+	}
+	if math.Abs(d.Output-18) > 1 {
+		t.Errorf("PL1 is %.2fW after 10 minutes, should be about 18W", d.Output)
+	}
+}
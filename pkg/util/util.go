@@ -2,10 +2,15 @@ package util
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
 // GetAllCPUs returns a list of integers corresponding to all CPUs on the system (e.g. on
@@ -34,6 +39,7 @@ func GetAllCPUs() ([]int, error) {
 	return cpus, nil
 }
 
+// IsValidCPU returns true if cpu is a CPU number that exists on this system
 func IsValidCPU(cpu int) bool {
 
 	// CPU must be a nonnegative integer
@@ -49,3 +55,125 @@ func IsValidCPU(cpu int) bool {
 
 	return true
 }
+
+// GetPackageCPUs returns the first CPU of each physical package among cpus, which is all
+// that's needed to read or write a package-scoped register once per package. A CPU whose
+// package can't be read from sysfs is kept, as if it were a package of its own.
+func GetPackageCPUs(cpus []int) []int {
+	seen := map[string]bool{}
+	var first []int
+
+	for _, cpu := range cpus {
+		buf, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/physical_package_id", cpu))
+		if err != nil {
+			first = append(first, cpu)
+			continue
+		}
+
+		pkg := strings.TrimSpace(string(buf))
+		if !seen[pkg] {
+			seen[pkg] = true
+			first = append(first, cpu)
+		}
+	}
+
+	return first
+}
+
+// ParseCPUList parses a list of CPUs in the same format the kernel uses in sysfs and on the
+// command line (e.g. "0,2-4" is [0, 2, 3, 4]). The result is sorted and deduplicated.
+func ParseCPUList(list string) ([]int, error) {
+	seen := map[int]bool{}
+	var cpus []int
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		bounds := strings.SplitN(item, "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list entry '%s'", item)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU range '%s'", item)
+			}
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// FormatCPUList is the inverse of ParseCPUList: it formats a sorted list of CPUs with
+// consecutive runs collapsed into ranges (e.g. [0, 2, 3, 4] is "0,2-4")
+func FormatCPUList(cpus []int) string {
+	var items []string
+
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+
+		if j == i {
+			items = append(items, strconv.Itoa(cpus[i]))
+		} else {
+			items = append(items, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(items, ",")
+}
+
+// ParseFrequencyMHz parses a frequency such as "3.2GHz", "800MHz" or "2400" (MHz is
+// assumed without a unit) into an integer number of MHz
+func ParseFrequencyMHz(freq string) (int, error) {
+	f := strings.ToLower(strings.TrimSpace(freq))
+	multiplier := 1.0
+
+	switch {
+	case strings.HasSuffix(f, "ghz"):
+		f = strings.TrimSuffix(f, "ghz")
+		multiplier = 1000
+	case strings.HasSuffix(f, "mhz"):
+		f = strings.TrimSuffix(f, "mhz")
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid frequency '%s'", freq)
+	}
+
+	return int(math.Round(value * multiplier)), nil
+}
+
+// ParsePercent parses a percentage such as "50%" or "62.5" into a float in [0, 100]
+func ParsePercent(percent string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(percent), "%"), 64)
+	if err != nil || value < 0 || value > 100 {
+		return 0, fmt.Errorf("invalid percentage '%s'", percent)
+	}
+
+	return value, nil
+}
+
+// SetCPUAffinity pins the calling OS thread to cpu. Callers will want to have called
+// runtime.LockOSThread first, or the Go scheduler will happily move them somewhere else.
+func SetCPUAffinity(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -9,11 +9,11 @@ import (
 func TestInvalidCPU(t *testing.T) {
 	t.Log("Testing invalid CPU detection")
 
-	if !isValidCPU(0) {
+	if !IsValidCPU(0) {
 		t.Errorf("Cpu 0 is valid")
 	}
 
-	if isValidCPU(-1) {
+	if IsValidCPU(-1) {
 		t.Errorf("Negative CPU number is not valid")
 	}
 
@@ -22,7 +22,7 @@ func TestInvalidCPU(t *testing.T) {
 	for i := 0; i < 4096; i++ {
 		cpuDir := fmt.Sprintf("/dev/cpu/%d", i)
 		if _, err := os.Stat(cpuDir); os.IsNotExist(err) {
-			if isValidCPU(i) {
+			if IsValidCPU(i) {
 				t.Errorf("nonexistent CPU %d is not valid", i)
 			} else {
 				break
@@ -30,3 +30,46 @@ func TestInvalidCPU(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := ParseCPUList("3,0-2, 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(cpus) != "[0 1 2 3]" {
+		t.Errorf("CPU list parsed to %v, should be [0 1 2 3]", cpus)
+	}
+
+	for _, list := range []string{"", "a", "3-1", "1-"} {
+		if _, err := ParseCPUList(list); err == nil {
+			t.Errorf("CPU list '%s' should not parse", list)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	if list := FormatCPUList([]int{0, 2, 3, 4, 7}); list != "0,2-4,7" {
+		t.Errorf("CPU list formatted to '%s', should be '0,2-4,7'", list)
+	}
+}
+
+func TestParseFrequencyMHz(t *testing.T) {
+	m := map[string]int{
+		"3.2GHz":  3200,
+		"800MHz":  800,
+		"2400":    2400,
+		"1.05ghz": 1050,
+	}
+
+	for k, v := range m {
+		mhz, err := ParseFrequencyMHz(k)
+		if err != nil || mhz != v {
+			t.Errorf("frequency '%s' parses to %d (%v), should be %d", k, mhz, err, v)
+		}
+	}
+
+	if _, err := ParseFrequencyMHz("fast"); err == nil {
+		t.Errorf("frequency 'fast' should not parse")
+	}
+}